@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// profileSeparator joins profiles that are applied together, as in
+// `devswitch apply work+kube-prod`.
+const profileSeparator = "+"
+
+// splitProfileSpec splits a possibly composed profile name into its members.
+func splitProfileSpec(spec string) []string {
+	var names []string
+	for _, name := range strings.Split(spec, profileSeparator) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func isComposite(name string) bool {
+	return strings.Contains(name, profileSeparator)
+}
+
+// profileExists reports whether name, or every member of a composed name,
+// is a profile.
+func profileExists(name string) error {
+	members := splitProfileSpec(name)
+	if len(members) == 0 {
+		return fmt.Errorf("profile name required")
+	}
+	for _, m := range members {
+		if err := checkName("profile", m); err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(profilesDir(), m)); err != nil {
+			return fmt.Errorf("profile %s does not exist", m)
+		}
+	}
+	return nil
+}
+
+// composition records which member of a composed profile provides each
+// file. It is what `current` reports once applied.
+type composition struct {
+	Profiles []string          `json:"profiles"`
+	Prefer   []string          `json:"prefer,omitempty"`
+	Files    map[string]string `json:"files"` // file -> profile
+}
+
+func (c *composition) name() string {
+	return strings.Join(c.Profiles, profileSeparator)
+}
+
+// composeProfiles decides which profile provides each file. Profiles are
+// expected to touch different files; when several provide the same one the
+// first of them named in prefer wins, otherwise composing fails.
+func composeProfiles(profiles, prefer []string) (*composition, error) {
+	comp := &composition{Profiles: profiles, Prefer: prefer, Files: map[string]string{}}
+	providers := map[string][]string{}
+	for _, p := range profiles {
+		rp, err := resolveProfile(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, rf := range rp.Files {
+			providers[rf.Name] = append(providers[rf.Name], p)
+		}
+	}
+
+	rank := map[string]int{}
+	for i, p := range prefer {
+		if _, ok := rank[p]; !ok {
+			rank[p] = i
+		}
+	}
+	var conflicts []string
+	for file, ps := range providers {
+		if len(ps) == 1 {
+			comp.Files[file] = ps[0]
+			continue
+		}
+		best, bestRank := "", len(prefer)
+		for _, p := range ps {
+			if r, ok := rank[p]; ok && r < bestRank {
+				best, bestRank = p, r
+			}
+		}
+		if best == "" {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", file, strings.Join(ps, ", ")))
+			continue
+		}
+		comp.Files[file] = best
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("profiles conflict on %s; pick a winner with --prefer", strings.Join(conflicts, ", "))
+	}
+	return comp, nil
+}
+
+// materializeComposition writes the chosen file of every member into the
+// build directory and returns it.
+func materializeComposition(comp *composition) (string, error) {
+	var files []*resolvedFile
+	for file, p := range comp.Files {
+		src, err := profileSourceDir(p)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(filepath.Join(src, file))
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(filepath.Join(src, file))
+		if err != nil {
+			return "", err
+		}
+		files = append(files, &resolvedFile{Name: file, Content: data, Perm: info.Mode().Perm()})
+	}
+	return publishBuild(comp.name(), files)
+}
+
+// composedManifest combines the manifests of the members. SSH hosts are
+// collected from all of them; other settings of later members win.
+func composedManifest(profiles []string) (*profileManifest, error) {
+	merged := &profileManifest{}
+	var hosts []sshHost
+	for _, p := range profiles {
+		m, err := loadResolvedManifest(p)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, m.SSH.Hosts...)
+		if m.SSH.Agent.Enabled {
+			merged.SSH.Agent.Enabled = true
+		}
+		if m.SSH.Agent.Lifetime != "" {
+			merged.SSH.Agent.Lifetime = m.SSH.Agent.Lifetime
+		}
+		merged.SSH.Agent.Keys = append(merged.SSH.Agent.Keys, m.SSH.Agent.Keys...)
+		for name, policy := range m.Watch {
+			if merged.Watch == nil {
+				merged.Watch = map[string]string{}
+			}
+			merged.Watch[name] = policy
+		}
+	}
+	merged.SSH.Hosts = hosts
+	return merged, nil
+}
+
+func originsPath() string {
+	return filepath.Join(devDir(), "current_files.json")
+}
+
+func writeOrigins(comp *composition) error {
+	data, err := json.MarshalIndent(comp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(originsPath(), data, 0o644)
+}
+
+// readOrigins returns the per-file origins of the active profile, or nil if
+// none were recorded.
+func readOrigins() *composition {
+	data, err := os.ReadFile(originsPath())
+	if err != nil {
+		return nil
+	}
+	comp := &composition{}
+	if err := json.Unmarshal(data, comp); err != nil {
+		return nil
+	}
+	return comp
+}
+
+func clearOrigins() error {
+	if err := os.Remove(originsPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// compositionFor returns the composition of a composed name, reusing the
+// precedence recorded when it was applied.
+func compositionFor(name string) (*composition, error) {
+	if rec := readOrigins(); rec != nil && rec.name() == name {
+		return composeProfiles(rec.Profiles, rec.Prefer)
+	}
+	return composeProfiles(splitProfileSpec(name), nil)
+}
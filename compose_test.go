@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeProfileFiles creates a profile holding files, name -> content.
+func writeProfileFiles(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	profPath := filepath.Join(profilesDir(), name)
+	if err := os.MkdirAll(profPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(profPath, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestComposeProfiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	writeProfileFiles(t, "base", map[string]string{".env": "A=1\n"})
+	writeProfileFiles(t, "work", map[string]string{
+		".gitconfig": "[user]\n\tname = work\n",
+		".npmrc":     "registry=https://work\n",
+		manifestName: "extends: [base]\n",
+	})
+	writeProfileFiles(t, "ops", map[string]string{
+		"aws_config": "[default]\nregion = eu-west-1\n",
+		".npmrc":     "registry=https://ops\n",
+	})
+	writeProfileFiles(t, "git", map[string]string{".gitconfig": "[user]\n\tname = git\n"})
+
+	tests := []struct {
+		name     string
+		profiles []string
+		prefer   []string
+		want     map[string]string
+		conflict string
+	}{
+		{
+			name:     "disjoint members",
+			profiles: []string{"git", "ops"},
+			want:     map[string]string{".gitconfig": "git", "aws_config": "ops", ".npmrc": "ops"},
+		},
+		{
+			name:     "files from extended profiles belong to the member",
+			profiles: []string{"work", "ops"},
+			prefer:   []string{"work"},
+			want:     map[string]string{".gitconfig": "work", ".npmrc": "work", ".env": "work", "aws_config": "ops"},
+		},
+		{
+			name:     "first preferred provider wins",
+			profiles: []string{"work", "ops", "git"},
+			prefer:   []string{"ops", "git", "work"},
+			want:     map[string]string{".gitconfig": "git", ".npmrc": "ops", ".env": "work", "aws_config": "ops"},
+		},
+		{
+			name:     "overlapping files need a preference",
+			profiles: []string{"work", "ops"},
+			conflict: ".npmrc (work, ops)",
+		},
+		{
+			name:     "preferring a profile that does not provide the file",
+			profiles: []string{"work", "ops", "git"},
+			prefer:   []string{"git"},
+			conflict: ".npmrc (work, ops)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp, err := composeProfiles(tt.profiles, tt.prefer)
+			if tt.conflict != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.conflict) {
+					t.Fatalf("got error %v, want a conflict on %s", err, tt.conflict)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(comp.Files, tt.want) {
+				t.Errorf("origins %v, want %v", comp.Files, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompositionForReusesPreference(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	writeProfileFiles(t, "work", map[string]string{".npmrc": "registry=https://work\n"})
+	writeProfileFiles(t, "ops", map[string]string{".npmrc": "registry=https://ops\n"})
+
+	if _, err := compositionFor("work+ops"); err == nil {
+		t.Fatal("expected a conflict without a recorded preference")
+	}
+	comp, err := composeProfiles([]string{"work", "ops"}, []string{"ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(devDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOrigins(comp); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := profileSourceDir("work+ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".npmrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "registry=https://ops\n" {
+		t.Errorf("composed .npmrc is %q, want the preferred profile's", data)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// detectMinScore is the agreement a profile needs before detect --fix
+// records it as the current profile.
+const detectMinScore = 50.0
+
+// Kinds of detect candidates.
+const (
+	candidateProfile = "profile"
+	candidateBackup  = "backup"
+)
+
+// fileAgreement is how closely one target matches a candidate's copy.
+type fileAgreement struct {
+	Name      string  `json:"name"`
+	Agreement float64 `json:"agreement"` // percent of matching lines
+	Missing   bool    `json:"missing,omitempty"`
+}
+
+// candidate is a profile or backup the current targets are compared with.
+type candidate struct {
+	Kind  string          `json:"kind"`
+	Name  string          `json:"name"`
+	Score float64         `json:"score"` // mean agreement of its files
+	Files []fileAgreement `json:"files"`
+	dir   string
+}
+
+// similarity returns the percentage of lines a and b have in common. Files
+// that differ and are binary or too large to diff count as 0.
+func similarity(a, b []byte) float64 {
+	if bytes.Equal(a, b) {
+		return 100
+	}
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		return 0
+	}
+	la, lb := splitLines(a), splitLines(b)
+	ops, ok := diffLines(la, lb)
+	if !ok {
+		return 0
+	}
+	common := 0
+	for _, op := range ops {
+		if op.Kind == ' ' {
+			common++
+		}
+	}
+	return 200 * float64(common) / float64(len(la)+len(lb))
+}
+
+// compare scores the current targets against the config files in dir.
+func (cd *candidate) compare(configs []configFile) {
+	total := 0.0
+	for _, cfg := range configs {
+		want, err := os.ReadFile(filepath.Join(cd.dir, cfg.Name))
+		if err != nil {
+			continue
+		}
+		fa := fileAgreement{Name: cfg.Name}
+		if have, err := os.ReadFile(cfg.Src()); err == nil {
+			fa.Agreement = similarity(have, want)
+		} else {
+			fa.Missing = true
+		}
+		cd.Files = append(cd.Files, fa)
+		total += fa.Agreement
+	}
+	if len(cd.Files) > 0 {
+		cd.Score = total / float64(len(cd.Files))
+	}
+}
+
+// detectCandidates fingerprints the targets against every profile and,
+// unless skipped, every backup, best match first.
+func detectCandidates(withBackups bool) ([]*candidate, error) {
+	configs := detectConfigFiles()
+	names, err := profileNames()
+	if err != nil {
+		return nil, err
+	}
+	var out []*candidate
+	for _, name := range names {
+		dir, err := profileSourceDir(name)
+		if err != nil {
+			color.Yellow("⚠️  Skipping profile %s: %v", name, err)
+			continue
+		}
+		out = append(out, &candidate{Kind: candidateProfile, Name: name, dir: dir})
+	}
+	if withBackups {
+		entries, _ := os.ReadDir(backupsDir())
+		for _, e := range entries {
+			if e.IsDir() {
+				out = append(out, &candidate{Kind: candidateBackup, Name: e.Name(), dir: filepath.Join(backupsDir(), e.Name())})
+			}
+		}
+	}
+
+	scored := out[:0]
+	for _, cd := range out {
+		cd.compare(configs)
+		if len(cd.Files) > 0 {
+			scored = append(scored, cd)
+		}
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		a, b := scored[i], scored[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if len(a.Files) != len(b.Files) {
+			return len(a.Files) > len(b.Files)
+		}
+		// profiles first, then the newest backup
+		if a.Kind != b.Kind {
+			return a.Kind == candidateProfile
+		}
+		return a.Kind == candidateProfile && a.Name < b.Name || a.Kind == candidateBackup && a.Name > b.Name
+	})
+	return scored, nil
+}
+
+// bestProfile is the best-matching profile among the candidates.
+func bestProfile(cands []*candidate) *candidate {
+	for _, cd := range cands {
+		if cd.Kind == candidateProfile {
+			return cd
+		}
+	}
+	return nil
+}
+
+func (cd *candidate) label() string {
+	if cd.Kind == candidateBackup {
+		return "backup " + cd.Name
+	}
+	return cd.Name
+}
+
+// repairCurrent records cd as the current profile. Files that match it
+// exactly are tracked as applied; the others are tracked as local edits of
+// its content, so that status and a later apply see them as such.
+func repairCurrent(recorded string, cd *candidate) error {
+	noteTransition(recorded, cd.Name)
+	st := newAppliedState(cd.Name, false)
+	for _, fa := range cd.Files {
+		if fa.Missing {
+			continue
+		}
+		cfg, ok := configByName(fa.Name)
+		if !ok {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(cd.dir, fa.Name))
+		if err != nil {
+			return err
+		}
+		if err := st.recordProfile(fa.Name, cfg.Src(), content); err != nil {
+			return err
+		}
+	}
+	if err := writeCurrentProfile(cd.Name); err != nil {
+		return err
+	}
+	if err := clearOrigins(); err != nil {
+		return err
+	}
+	if err := st.save(); err != nil {
+		return err
+	}
+	return bumpGeneration()
+}
+
+func cmdDetect(c *cli.Context) error {
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	cands, err := detectCandidates(!c.Bool("no-backups"))
+	if err != nil {
+		return err
+	}
+	recorded, _ := readCurrentProfile()
+	limit := c.Int("limit")
+	if limit < 0 {
+		return fmt.Errorf("--limit must be 0 (all) or more, got %d", limit)
+	}
+	if limit == 0 || limit > len(cands) {
+		limit = len(cands)
+	}
+
+	if c.Bool("json") {
+		out := struct {
+			Recorded   string       `json:"recorded,omitempty"`
+			Candidates []*candidate `json:"candidates"`
+		}{recorded, cands[:limit]}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else if len(cands) == 0 {
+		boxInfo("Nothing Detected", "No profile or backup has any of the managed files")
+		return nil
+	} else {
+		best := cands[0]
+		var b strings.Builder
+		fmt.Fprintf(&b, "Best match: %s (%.1f%%)\n", best.label(), best.Score)
+		for _, fa := range best.Files {
+			state := fmt.Sprintf("%5.1f%%", fa.Agreement)
+			if fa.Missing {
+				state = "missing"
+			}
+			fmt.Fprintf(&b, "\n  %-20s %s", fa.Name, state)
+		}
+		if limit > 1 {
+			b.WriteString("\n\nOther candidates:")
+			for _, cd := range cands[1:limit] {
+				fmt.Fprintf(&b, "\n  %-28s %5.1f%%  (%d files)", cd.label(), cd.Score, len(cd.Files))
+			}
+		}
+		fmt.Fprintf(&b, "\n\nRecorded current profile: %s", profileLabel(recorded))
+		boxInfo("Detected Profile", b.String())
+	}
+
+	best := bestProfile(cands)
+	if !c.Bool("fix") {
+		if best != nil && best.Name != recorded && best.Score >= detectMinScore && !c.Bool("json") {
+			color.Yellow("⚠️  The files look like %s; run 'devswitch detect --fix' to record it", best.Name)
+		}
+		return nil
+	}
+	switch {
+	case best == nil || best.Score < detectMinScore:
+		return fmt.Errorf("no profile matches the current files well enough (needs %.0f%%); apply one instead", detectMinScore)
+	case best.Name == recorded:
+		color.Green("✅ %s is already recorded as the current profile", recorded)
+		return nil
+	}
+	err = logged(func(c *cli.Context) error { return repairCurrent(recorded, best) })(c)
+	if err != nil {
+		return err
+	}
+	color.Green("✅ Recorded %s as the current profile (was %s)", best.Name, profileLabel(recorded))
+	return nil
+}
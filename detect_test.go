@@ -0,0 +1,32 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want float64
+	}{
+		{"identical", "a\nb\n", "a\nb\n", 100},
+		{"both empty", "", "", 100},
+		{"one line of four changed", "a\nb\nc\nd\n", "a\nb\nc\nX\n", 75},
+		{"lines added", "a\nb\n", "a\nb\nc\nd\n", 200.0 * 2 / 6},
+		{"nothing in common", "a\nb\n", "c\nd\n", 0},
+		{"empty against content", "", "a\n", 0},
+		{"missing final newline", "a\nb", "a\nb\n", 100},
+		{"different binary files", "a\x00b", "a\x00c", 0},
+		{"identical binary files", "a\x00b", "a\x00b", 100},
+	}
+	for _, tt := range tests {
+		if got := similarity([]byte(tt.a), []byte(tt.b)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: similarity = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := similarity([]byte(tt.b), []byte(tt.a)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s, reversed: similarity = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
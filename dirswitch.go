@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// dirBinding ties a directory tree to a profile without a file in the tree.
+type dirBinding struct {
+	Path    string `yaml:"path"`
+	Profile string `yaml:"profile"`
+}
+
+type bindingsFile struct {
+	Bindings []dirBinding `yaml:"bindings"`
+}
+
+func bindingsPath() string {
+	return filepath.Join(devDir(), "bindings.yaml")
+}
+
+func loadBindings() (*bindingsFile, error) {
+	b := &bindingsFile{}
+	data, err := os.ReadFile(bindingsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", bindingsPath(), err)
+	}
+	return b, nil
+}
+
+func (b *bindingsFile) save() error {
+	sort.Slice(b.Bindings, func(i, j int) bool { return b.Bindings[i].Path < b.Bindings[j].Path })
+	data, err := yaml.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(bindingsPath(), data, 0o644)
+}
+
+func (b *bindingsFile) lookup(dir string) string {
+	for _, bd := range b.Bindings {
+		if bd.Path == dir {
+			return bd.Profile
+		}
+	}
+	return ""
+}
+
+// writeFileAtomic replaces path in one step so concurrent readers never see
+// a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// dirMatch is the profile a directory resolves to.
+type dirMatch struct {
+	Profile string `json:"profile"`
+	Root    string `json:"root"`              // directory the binding applies to
+	Source  string `json:"source"`            // local config file, or the bindings table
+	Blocked string `json:"blocked,omitempty"` // why an untrusted config was ignored
+}
+
+// dirCacheEntry remembers a lookup together with the modification times it
+// depends on: every directory walked (adding or removing a config file
+// changes its mtime), the config file found, the bindings table and the
+// trust store.
+type dirCacheEntry struct {
+	Match *dirMatch        `json:"match,omitempty"`
+	Deps  map[string]int64 `json:"deps"`
+}
+
+const dirCacheLimit = 512
+
+func dirCachePath() string {
+	return filepath.Join(devDir(), "cache", "dirs.json")
+}
+
+func mtimeOf(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.ModTime().UnixNano()
+}
+
+func (e *dirCacheEntry) valid() bool {
+	for path, mtime := range e.Deps {
+		if mtimeOf(path) != mtime {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveDirProfileUncached walks from dir towards the root and returns the
+// nearest binding; an explicit entry in the bindings table beats a local
+// config file in the same directory. Local config files are only honoured
+// once allowed; a denied one hides the tree silently.
+func resolveDirProfileUncached(dir string) (*dirMatch, map[string]int64, error) {
+	deps := map[string]int64{
+		bindingsPath(): mtimeOf(bindingsPath()),
+		trustPath():    mtimeOf(trustPath()),
+	}
+	bindings, err := loadBindings()
+	if err != nil {
+		return nil, nil, err
+	}
+	trust, err := loadTrustStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		deps[d] = mtimeOf(d)
+		if profile := bindings.lookup(d); profile != "" {
+			return &dirMatch{Profile: profile, Root: d, Source: bindingsPath()}, deps, nil
+		}
+		if cfg := localConfigIn(d); cfg != "" {
+			deps[cfg] = mtimeOf(cfg)
+			lc, err := loadLocalConfig(cfg)
+			if err != nil {
+				return nil, nil, err
+			}
+			if lc.Profile != "" {
+				state, err := trust.state(cfg)
+				if err != nil {
+					return nil, nil, err
+				}
+				switch state {
+				case trustAllowed:
+					return &dirMatch{Profile: lc.Profile, Root: d, Source: cfg}, deps, nil
+				case trustDenied:
+					return nil, deps, nil
+				}
+				return &dirMatch{Root: d, Source: cfg, Blocked: trustReason(cfg, state)}, deps, nil
+			}
+		}
+		if filepath.Dir(d) == d {
+			return nil, deps, nil
+		}
+	}
+}
+
+// resolveDirProfile returns the profile bound to dir, or nil. Results are
+// cached in the state directory because the shell asks on every cd.
+func resolveDirProfile(dir string) (*dirMatch, error) {
+	cache := map[string]*dirCacheEntry{}
+	if data, err := os.ReadFile(dirCachePath()); err == nil {
+		// a corrupt cache is simply rebuilt
+		_ = json.Unmarshal(data, &cache)
+	}
+	if e, ok := cache[dir]; ok && e.valid() {
+		return e.Match, nil
+	}
+
+	match, deps, err := resolveDirProfileUncached(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(cache) >= dirCacheLimit {
+		cache = map[string]*dirCacheEntry{}
+	}
+	cache[dir] = &dirCacheEntry{Match: match, Deps: deps}
+	if data, err := json.Marshal(cache); err == nil {
+		_ = writeFileAtomic(dirCachePath(), data, 0o644)
+	}
+	return match, nil
+}
+
+// Shell variables that track the directory session. __DEVSWITCH_SAVED_<KEY>
+// holds a value that was replaced, so it can be restored on the way out.
+const (
+	dirVarProfile = "__DEVSWITCH_DIR_PROFILE"
+	dirVarRoot    = "__DEVSWITCH_DIR_ROOT"
+	dirVarList    = "__DEVSWITCH_DIR_VARS"
+	dirSavedVar   = "__DEVSWITCH_SAVED_"
+)
+
+// hookDirScript activates the profile bound to dir in the calling shell, or
+// reverts the previous directory session when leaving its tree. Only
+// variables are changed; global files are never written.
+func hookDirScript(shell, dir string) (string, error) {
+	s := &shellScript{shell: shell}
+
+	match, err := resolveDirProfile(dir)
+	if err != nil {
+		return "", err
+	}
+	want, root := "", ""
+	if match != nil && match.Blocked == "" {
+		want, root = match.Profile, match.Root
+	}
+	if match != nil && match.Blocked != "" {
+		s.echo("devswitch: " + match.Blocked)
+	}
+	if want == os.Getenv(dirVarProfile) && root == os.Getenv(dirVarRoot) {
+		return s.String(), nil
+	}
+
+	if os.Getenv(dirVarProfile) != "" {
+		for _, key := range strings.Split(os.Getenv(dirVarList), ":") {
+			if !envKeyPattern.MatchString(key) {
+				continue
+			}
+			if saved, ok := os.LookupEnv(dirSavedVar + key); ok {
+				s.export(key, saved)
+				s.unset(dirSavedVar + key)
+			} else {
+				s.unset(key)
+			}
+		}
+		s.unset(dirVarProfile)
+		s.unset(dirVarRoot)
+		s.unset(dirVarList)
+		if want == "" {
+			s.echo(fmt.Sprintf("devswitch: left %s", os.Getenv(dirVarProfile)))
+		}
+	}
+	if want == "" {
+		return s.String(), nil
+	}
+
+	scratch := filepath.Join(devDir(), "sessions", want)
+	vars, err := sessionEnv(want, scratch)
+	if err != nil {
+		return "", err
+	}
+	// After a revert above, the saved values are what is current again.
+	leaving := map[string]bool{}
+	if os.Getenv(dirVarProfile) != "" {
+		for _, key := range strings.Split(os.Getenv(dirVarList), ":") {
+			leaving[key] = true
+		}
+	}
+	var keys []string
+	seen := map[string]bool{}
+	for _, v := range vars {
+		if !seen[v.Key] {
+			seen[v.Key] = true
+			keys = append(keys, v.Key)
+			orig, ok := os.LookupEnv(v.Key)
+			if leaving[v.Key] {
+				orig, ok = os.LookupEnv(dirSavedVar + v.Key)
+			}
+			if ok {
+				s.export(dirSavedVar+v.Key, orig)
+			}
+		}
+		s.export(v.Key, v.Value)
+	}
+	s.export(dirVarProfile, want)
+	s.export(dirVarRoot, root)
+	s.export(dirVarList, strings.Join(keys, ":"))
+	s.echo(fmt.Sprintf("devswitch: using %s for %s", want, root))
+	return s.String(), nil
+}
+
+func cmdHookDir(c *cli.Context) error {
+	shell, err := checkShell(c)
+	if err != nil {
+		return hookFailed(err)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return hookFailed(err)
+	}
+	script, err := hookDirScript(shell, dir)
+	if err != nil {
+		// a broken binding must not break the prompt
+		return hookFailed(err)
+	}
+	fmt.Print(script)
+	return nil
+}
+
+func bindTarget(c *cli.Context, idx int) (string, error) {
+	dir := c.Args().Get(idx)
+	if dir == "" {
+		return os.Getwd()
+	}
+	return filepath.Abs(dir)
+}
+
+func cmdBind(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return fmt.Errorf("profile name required")
+	}
+	profile := c.Args().First()
+	if err := profileExists(profile); err != nil {
+		return err
+	}
+	dir, err := bindTarget(c, 1)
+	if err != nil {
+		return err
+	}
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+
+	bindings, err := loadBindings()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range bindings.Bindings {
+		if bindings.Bindings[i].Path == dir {
+			bindings.Bindings[i].Profile = profile
+			replaced = true
+		}
+	}
+	if !replaced {
+		bindings.Bindings = append(bindings.Bindings, dirBinding{Path: dir, Profile: profile})
+	}
+	if err := bindings.save(); err != nil {
+		return err
+	}
+	boxInfo("Directory Bound", fmt.Sprintf("%s\n\n→ %s", dir, profile))
+	return nil
+}
+
+func cmdUnbind(c *cli.Context) error {
+	dir, err := bindTarget(c, 0)
+	if err != nil {
+		return err
+	}
+	bindings, err := loadBindings()
+	if err != nil {
+		return err
+	}
+	kept := bindings.Bindings[:0]
+	for _, bd := range bindings.Bindings {
+		if bd.Path != dir {
+			kept = append(kept, bd)
+		}
+	}
+	if len(kept) == len(bindings.Bindings) {
+		return fmt.Errorf("%s is not bound to a profile", dir)
+	}
+	bindings.Bindings = kept
+	if err := bindings.save(); err != nil {
+		return err
+	}
+	boxInfo("Directory Unbound", dir)
+	return nil
+}
+
+func cmdBindings(c *cli.Context) error {
+	bindings, err := loadBindings()
+	if err != nil {
+		return err
+	}
+	if len(bindings.Bindings) == 0 {
+		boxInfo("No Bindings", "Bind a directory with 'devswitch bind <profile> [dir]'")
+		return nil
+	}
+	list := "\n"
+	for _, bd := range bindings.Bindings {
+		list += fmt.Sprintf("  • %s → %s\n", bd.Path, bd.Profile)
+	}
+	boxInfo("Directory Bindings", list)
+	return nil
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// doctorIssue is a problem found by doctor with the advice shown for it and,
+// when it is safe to do unattended, the repair --fix performs.
+type doctorIssue struct {
+	Problem string
+	Advice  string
+	Warning bool         // reported, but does not make doctor fail
+	repair  func() error // nil when the fix is left to the user
+}
+
+// doctorCheck is one area doctor looks at. Checks run in order and --fix
+// repairs each area before the next is checked.
+type doctorCheck struct {
+	Name string
+	run  func() ([]*doctorIssue, error)
+}
+
+func doctorChecks() []doctorCheck {
+	return []doctorCheck{
+		{"State directory", checkStateDir},
+		{"Current profile", checkCurrentProfile},
+		{"Symlinks", checkSymlinks},
+		{"Backups", checkBackups},
+		{"Private keys", checkKeyPermissions},
+		{"Profiles", checkProfiles},
+		{"Tools", checkTools},
+		{"Bindings", checkBindings},
+	}
+}
+
+func checkStateDir() ([]*doctorIssue, error) {
+	var issues []*doctorIssue
+	for _, dir := range []string{devDir(), profilesDir(), backupsDir()} {
+		dir := dir
+		info, err := os.Stat(dir)
+		switch {
+		case os.IsNotExist(err):
+			issues = append(issues, &doctorIssue{
+				Problem: dir + " is missing",
+				Advice:  "mkdir -p " + dir,
+				repair:  func() error { return os.MkdirAll(dir, 0o755) },
+			})
+		case err != nil:
+			issues = append(issues, &doctorIssue{
+				Problem: err.Error(),
+				Advice:  "check the permissions of " + filepath.Dir(dir),
+			})
+		case !info.IsDir():
+			issues = append(issues, &doctorIssue{
+				Problem: dir + " is not a directory",
+				Advice:  "move it out of the way; devswitch recreates the directory",
+			})
+		case runtime.GOOS == "windows":
+		case info.Mode().Perm()&0o700 != 0o700:
+			perm := info.Mode().Perm()
+			issues = append(issues, &doctorIssue{
+				Problem: fmt.Sprintf("%s is not usable by you (%04o)", dir, perm),
+				Advice:  "chmod u+rwx " + dir,
+				repair:  func() error { return os.Chmod(dir, perm|0o700) },
+			})
+		case info.Mode().Perm()&0o022 != 0:
+			perm := info.Mode().Perm()
+			issues = append(issues, &doctorIssue{
+				Problem: fmt.Sprintf("%s is writable by other users (%04o)", dir, perm),
+				Advice:  "chmod go-w " + dir,
+				repair:  func() error { return os.Chmod(dir, perm&^0o022) },
+			})
+		}
+	}
+
+	// state files devswitch refuses to work with when they are corrupt
+	loaders := []struct {
+		path string
+		load func() error
+	}{
+		{profileTimesPath(), func() error { _, err := loadProfileTimes(); return err }},
+		{bindingsPath(), func() error { _, err := loadBindings(); return err }},
+		{appliedStatePath(), func() error { _, err := loadAppliedState(); return err }},
+	}
+	for _, l := range loaders {
+		if err := l.load(); err != nil {
+			issues = append(issues, &doctorIssue{
+				Problem: err.Error(),
+				Advice:  "fix or remove " + l.path,
+			})
+		}
+	}
+	return issues, nil
+}
+
+// clearCurrentProfile forgets the active profile without touching the
+// config files, as rollback does.
+func clearCurrentProfile() error {
+	if err := os.Remove(filepath.Join(devDir(), "current_profile.txt")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := clearOrigins(); err != nil {
+		return err
+	}
+	if err := clearAppliedState(); err != nil {
+		return err
+	}
+	return bumpGeneration()
+}
+
+func checkCurrentProfile() ([]*doctorIssue, error) {
+	current, err := readCurrentProfile()
+	if err != nil || current == "" {
+		return nil, nil
+	}
+	if err := profileExists(current); err != nil {
+		return []*doctorIssue{{
+			Problem: fmt.Sprintf("the current profile is %s, but %v", current, err),
+			Advice:  "apply another profile, or run 'devswitch detect --fix'",
+			repair:  clearCurrentProfile,
+		}}, nil
+	}
+	return nil, nil
+}
+
+// checkSymlinks finds links that point nowhere in the state directory, such
+// as session links into a deleted profile, and at the config file targets.
+func checkSymlinks() ([]*doctorIssue, error) {
+	var links []string
+	filepath.WalkDir(devDir(), func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type()&fs.ModeSymlink != 0 {
+			links = append(links, p)
+		}
+		return nil
+	})
+	for _, cfg := range detectConfigFiles() {
+		if info, err := os.Lstat(cfg.Src()); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			links = append(links, cfg.Src())
+		}
+	}
+
+	var issues []*doctorIssue
+	for _, link := range links {
+		link := link
+		if _, err := os.Stat(link); !os.IsNotExist(err) {
+			continue
+		}
+		target, _ := os.Readlink(link)
+		issue := &doctorIssue{
+			Problem: fmt.Sprintf("%s points to %s, which does not exist", link, target),
+			Advice:  "rm " + link,
+		}
+		resolved := target
+		if !filepath.IsAbs(resolved) {
+			resolved = filepath.Join(filepath.Dir(link), resolved)
+		}
+		// links at the config locations may be the user's own; only
+		// devswitch's links into its state directory are removed
+		switch {
+		case isWithin(devDir(), link) && isWithin(devDir(), resolved):
+			issue.repair = func() error { return os.Remove(link) }
+		case !isWithin(devDir(), link):
+			issue.Advice = "re-apply the profile, or rm " + link + " if the link is not needed"
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+func checkBackups() ([]*doctorIssue, error) {
+	var issues []*doctorIssue
+	seen := map[string]bool{}
+	filepath.WalkDir(backupsDir(), func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			var f *os.File
+			if f, err = os.Open(p); err == nil {
+				f.Close()
+			}
+		}
+		if err == nil || seen[p] || (p == backupsDir() && os.IsNotExist(err)) {
+			return nil
+		}
+		seen[p] = true
+		issue := &doctorIssue{
+			Problem: fmt.Sprintf("cannot read %s: %v", p, err),
+			Advice:  "fix its permissions, or remove the backup if it is not needed",
+		}
+		if os.IsPermission(err) {
+			issue.Advice = "chmod -R u+rX " + p
+			issue.repair = func() error { return restoreOwnerRead(p) }
+		}
+		issues = append(issues, issue)
+		return nil
+	})
+	return issues, nil
+}
+
+// restoreOwnerRead gives the owner back read access to a file, or read and
+// search access to a directory and everything below it.
+func restoreOwnerRead(path string) error {
+	// each directory is visited, and made readable, before it is read
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		bits := fs.FileMode(0o400)
+		if d.IsDir() {
+			bits = 0o500
+		}
+		return os.Chmod(p, info.Mode().Perm()|bits)
+	})
+}
+
+// privateKeyFiles lists the private keys devswitch stores or writes: the
+// ssh_id_* files of profiles, backups and undo snapshots, the extra keys
+// profiles name, and the keys in ~/.ssh.
+func privateKeyFiles() []string {
+	var files []string
+	for _, root := range []string{profilesDir(), backupsDir(), undoDir()} {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && isTemplateSecret(d.Name()) {
+				files = append(files, p)
+			}
+			return nil
+		})
+	}
+	if names, err := profileNames(); err == nil {
+		for _, name := range names {
+			profPath := filepath.Join(profilesDir(), name)
+			if m, err := loadManifest(profPath); err == nil {
+				files = append(files, profileIdentityFiles(profPath, m)...)
+			}
+		}
+	}
+	for _, cfg := range detectConfigFiles() {
+		if isTemplateSecret(cfg.Name) {
+			files = append(files, cfg.Src())
+		}
+	}
+	return files
+}
+
+func checkKeyPermissions() ([]*doctorIssue, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil
+	}
+	var issues []*doctorIssue
+	seen := map[string]bool{}
+	for _, f := range privateKeyFiles() {
+		f := f
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		info, err := os.Stat(f)
+		if err != nil || info.Mode().Perm()&0o077 == 0 {
+			continue
+		}
+		issues = append(issues, &doctorIssue{
+			Problem: fmt.Sprintf("private key %s is accessible by other users (%04o)", f, info.Mode().Perm()),
+			Advice:  "chmod 600 " + f,
+			repair:  func() error { return os.Chmod(f, 0o600) },
+		})
+	}
+	return issues, nil
+}
+
+func checkProfiles() ([]*doctorIssue, error) {
+	names, err := profileNames()
+	if os.IsNotExist(err) {
+		// reported by the state directory check
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var issues []*doctorIssue
+	for _, name := range names {
+		dir, err := profileSourceDir(name)
+		var checks []fileCheck
+		if err == nil {
+			checks, err = validateDir(name, dir, nil)
+		}
+		if err != nil {
+			issues = append(issues, &doctorIssue{
+				Problem: fmt.Sprintf("%s cannot be resolved: %v", name, err),
+				Advice:  "devswitch profile edit " + name,
+			})
+			continue
+		}
+		for _, fc := range failedChecks(checks) {
+			profile, file := name, fc.Name
+			if layer, f, ok := strings.Cut(fc.Name, "/"); ok {
+				profile, file = layer, f
+			}
+			msg, _, _ := strings.Cut(fc.Err.Error(), "\n")
+			issues = append(issues, &doctorIssue{
+				Problem: fmt.Sprintf("%s: %s is invalid: %s", name, fc.Name, msg),
+				Advice:  fmt.Sprintf("devswitch profile edit %s %s", profile, file),
+			})
+		}
+		for _, fc := range checks {
+			if fc.Warning == "" {
+				continue
+			}
+			profile, file := name, fc.Name
+			if layer, f, ok := strings.Cut(fc.Name, "/"); ok {
+				profile, file = layer, f
+			}
+			issues = append(issues, &doctorIssue{
+				Problem: fmt.Sprintf("%s: %s: %s", name, fc.Name, fc.Warning),
+				Advice:  fmt.Sprintf("devswitch profile edit %s %s", profile, file),
+				Warning: true,
+			})
+		}
+	}
+	return issues, nil
+}
+
+// profileTools are the programs a profile file is meant for.
+var profileTools = []struct {
+	file, tool string
+}{
+	{".gitconfig", "git"},
+	{"settings.json", "code"},
+	{"kube_config", "kubectl"},
+}
+
+func checkTools() ([]*doctorIssue, error) {
+	names, err := profileNames()
+	if os.IsNotExist(err) {
+		// reported by the state directory check
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	users := map[string][]string{} // tool -> profiles using it
+	agentUsers := []string{}
+	for _, name := range names {
+		layers, err := profileLayers(name)
+		if err != nil {
+			// reported by the profile check
+			continue
+		}
+		for _, t := range profileTools {
+			for _, layer := range layers {
+				if _, err := os.Stat(filepath.Join(profilesDir(), layer, t.file)); err == nil {
+					users[t.tool] = append(users[t.tool], name)
+					break
+				}
+			}
+		}
+		if m, err := loadManifest(filepath.Join(profilesDir(), name)); err == nil && m.SSH.Agent.Enabled {
+			agentUsers = append(agentUsers, name)
+		}
+	}
+	if len(agentUsers) > 0 {
+		users["ssh-agent"] = agentUsers
+	}
+
+	var tools []string
+	for tool := range users {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	var issues []*doctorIssue
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			issues = append(issues, &doctorIssue{
+				Problem: fmt.Sprintf("%s is not installed, but used by %s", tool, strings.Join(users[tool], ", ")),
+				Advice:  fmt.Sprintf("install %s or add it to PATH", tool),
+				Warning: true,
+			})
+		}
+	}
+	if len(agentUsers) > 0 && os.Getenv("SSH_AUTH_SOCK") == "" {
+		issues = append(issues, &doctorIssue{
+			Problem: fmt.Sprintf("ssh-agent is not running, but used by %s", strings.Join(agentUsers, ", ")),
+			Advice:  `eval "$(ssh-agent)"`,
+			Warning: true,
+		})
+	}
+	return issues, nil
+}
+
+// dedupeBinding drops repeated entries for dir that name the same profile.
+func dedupeBinding(dir string) error {
+	bindings, err := loadBindings()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	kept := bindings.Bindings[:0]
+	for _, bd := range bindings.Bindings {
+		if filepath.Clean(bd.Path) == dir {
+			if seen[bd.Profile] {
+				continue
+			}
+			seen[bd.Profile] = true
+			bd.Path = dir
+		}
+		kept = append(kept, bd)
+	}
+	bindings.Bindings = kept
+	return bindings.save()
+}
+
+func checkBindings() ([]*doctorIssue, error) {
+	bindings, err := loadBindings()
+	if err != nil {
+		// reported by the state directory check
+		return nil, nil
+	}
+	byDir := map[string][]string{}
+	var dirs []string
+	for _, bd := range bindings.Bindings {
+		dir := filepath.Clean(bd.Path)
+		if byDir[dir] == nil {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], bd.Profile)
+	}
+
+	var issues []*doctorIssue
+	for _, dir := range dirs {
+		dir := dir
+		profiles := byDir[dir]
+		distinct := map[string]bool{}
+		for _, p := range profiles {
+			distinct[p] = true
+		}
+		if len(distinct) > 1 {
+			issues = append(issues, &doctorIssue{
+				Problem: fmt.Sprintf("%s is bound to %s; the first one wins", dir, strings.Join(profiles, " and ")),
+				Advice:  fmt.Sprintf("devswitch bind <profile> %s", dir),
+			})
+		} else if len(profiles) > 1 {
+			issues = append(issues, &doctorIssue{
+				Problem: fmt.Sprintf("%s is bound to %s %d times", dir, profiles[0], len(profiles)),
+				Advice:  "remove the repeated entries from " + bindingsPath(),
+				repair:  func() error { return dedupeBinding(dir) },
+			})
+		}
+		for p := range distinct {
+			if err := profileExists(p); err != nil {
+				issues = append(issues, &doctorIssue{
+					Problem: fmt.Sprintf("%s is bound to %s, but %v", dir, p, err),
+					Advice:  "devswitch unbind " + dir,
+				})
+			}
+		}
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			issues = append(issues, &doctorIssue{
+				Problem: dir + " is bound, but no longer exists",
+				Advice:  "devswitch unbind " + dir,
+				Warning: true,
+			})
+			continue
+		}
+		if cfg := localConfigIn(dir); cfg != "" && len(distinct) == 1 {
+			lc, err := loadLocalConfig(cfg)
+			if err == nil && lc.Profile != "" && lc.Profile != profiles[0] {
+				issues = append(issues, &doctorIssue{
+					Problem: fmt.Sprintf("the binding of %s to %s hides %s, which names %s", dir, profiles[0], cfg, lc.Profile),
+					Advice:  fmt.Sprintf("devswitch unbind %s, or change the profile in %s", dir, cfg),
+					Warning: true,
+				})
+			}
+		}
+	}
+	return issues, nil
+}
+
+func cmdDoctor(c *cli.Context) error {
+	fix := c.Bool("fix")
+	var b strings.Builder
+	problems, warnings, fixed, fixable := 0, 0, 0, 0
+	for _, check := range doctorChecks() {
+		issues, err := check.run()
+		if err != nil {
+			issues = []*doctorIssue{{Problem: err.Error(), Advice: "fix the error and run doctor again"}}
+		}
+		if len(issues) == 0 {
+			fmt.Fprintf(&b, "✓ %s\n", check.Name)
+			continue
+		}
+
+		var lines strings.Builder
+		failed, warned := false, false
+		for _, issue := range issues {
+			if fix && issue.repair != nil {
+				if err := issue.repair(); err != nil {
+					fmt.Fprintf(&lines, "    ✗ %s\n      → fix failed: %v; %s\n", issue.Problem, err, issue.Advice)
+					problems++
+					failed = true
+				} else {
+					fmt.Fprintf(&lines, "    ✔ %s (fixed)\n", issue.Problem)
+					fixed++
+				}
+				continue
+			}
+			mark := "✗"
+			if issue.Warning {
+				mark = "!"
+				warnings++
+				warned = true
+			} else {
+				problems++
+				failed = true
+			}
+			if issue.repair != nil {
+				fixable++
+			}
+			fmt.Fprintf(&lines, "    %s %s\n      → %s\n", mark, issue.Problem, issue.Advice)
+		}
+		mark := "✓"
+		switch {
+		case failed:
+			mark = "✗"
+		case warned:
+			mark = "!"
+		}
+		fmt.Fprintf(&b, "%s %s\n%s", mark, check.Name, lines.String())
+	}
+
+	switch {
+	case problems == 0 && warnings == 0 && fixed == 0:
+		b.WriteString("\nEverything looks fine")
+	default:
+		fmt.Fprintf(&b, "\n%d problem(s), %d warning(s)", problems, warnings)
+		if fixed > 0 {
+			fmt.Fprintf(&b, ", %d fixed", fixed)
+		}
+		if fixable > 0 {
+			fmt.Fprintf(&b, "\nRun 'devswitch doctor --fix' to repair %d automatically", fixable)
+		}
+	}
+	boxInfo("DevSwitch Doctor", b.String())
+	if problems > 0 {
+		return cli.Exit(fmt.Sprintf("doctor found %d problem(s)", problems), 1)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Lifecycle events. Hooks of a pre-event can veto the operation by
+// exiting non-zero; failures of post-event hooks are only reported.
+const (
+	hookPreApply     = "pre-apply"
+	hookPostApply    = "post-apply"
+	hookPreRollback  = "pre-rollback"
+	hookPostRollback = "post-rollback"
+)
+
+const defaultHookTimeout = 30 * time.Second
+
+// hookOutputLimit caps how much hook output is kept in the operation log.
+const hookOutputLimit = 64 << 10
+
+// hookSpec is a hook declared in the manifest:
+//
+//	hooks:
+//	  post-apply:
+//	    - command: git config --global core.editor "code --wait"
+//	      timeout: 10s
+type hookSpec struct {
+	Command string `yaml:"command"`
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// changedFile is a target that an operation replaces.
+type changedFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// hookContext is written to every hook as JSON on stdin.
+type hookContext struct {
+	Event        string        `json:"event"`
+	OldProfile   string        `json:"old_profile"`
+	NewProfile   string        `json:"new_profile"`
+	ChangedFiles []changedFile `json:"changed_files"`
+	Backup       string        `json:"backup,omitempty"`
+}
+
+// hookResult records one hook run.
+type hookResult struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Profile  string    `json:"profile"`
+	Hook     string    `json:"hook"`
+	ExitCode int       `json:"exit_code"`
+	Duration string    `json:"duration"`
+	Output   string    `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type hook struct {
+	Profile string
+	Name    string
+	Dir     string
+	Command string // run through the shell
+	Path    string // executable from hooks/<event>.d
+	Timeout time.Duration
+}
+
+func isPreEvent(event string) bool {
+	return strings.HasPrefix(event, "pre-")
+}
+
+// profileHooks collects the hooks of a possibly composed profile for event:
+// the manifest hooks first, then the executables in hooks/<event>.d of
+// every layer, in name order.
+func profileHooks(profile, event string, timeout time.Duration) ([]hook, error) {
+	var hooks []hook
+	for _, member := range splitProfileSpec(profile) {
+		m, err := loadResolvedManifest(member)
+		if err != nil {
+			return nil, err
+		}
+		for i, spec := range m.Hooks[event] {
+			h := hook{
+				Profile: member,
+				Name:    fmt.Sprintf("%s[%d]", manifestName, i),
+				Dir:     filepath.Join(profilesDir(), member),
+				Command: spec.Command,
+				Timeout: timeout,
+			}
+			if spec.Timeout != "" {
+				if h.Timeout, err = time.ParseDuration(spec.Timeout); err != nil {
+					return nil, fmt.Errorf("invalid timeout %q for %s hook in %s: %v", spec.Timeout, event, member, err)
+				}
+			}
+			hooks = append(hooks, h)
+		}
+
+		layers, err := profileLayers(member)
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			dir := filepath.Join(profilesDir(), layer, "hooks", event+".d")
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+					continue
+				}
+				info, err := e.Info()
+				if err != nil {
+					return nil, err
+				}
+				if runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+					color.Yellow("⚠️  Skipping hook %s (not executable)", filepath.Join(dir, e.Name()))
+					continue
+				}
+				hooks = append(hooks, hook{
+					Profile: layer,
+					Name:    filepath.Join("hooks", event+".d", e.Name()),
+					Dir:     filepath.Join(profilesDir(), layer),
+					Path:    filepath.Join(dir, e.Name()),
+					Timeout: timeout,
+				})
+			}
+		}
+	}
+	return hooks, nil
+}
+
+func (h *hook) command(ctx context.Context) *exec.Cmd {
+	if h.Path != "" {
+		return exec.CommandContext(ctx, h.Path)
+	}
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", h.Command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", h.Command)
+}
+
+// run executes the hook with the context on stdin. Output is shown as it
+// happens and kept for the log.
+func (h *hook) run(hc *hookContext) hookResult {
+	res := hookResult{Time: time.Now(), Event: hc.Event, Profile: h.Profile, Hook: h.Name}
+	input, err := json.Marshal(hc)
+	if err != nil {
+		res.ExitCode, res.Error = -1, err.Error()
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+	cmd := h.command(ctx)
+	cmd.Dir = h.Dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"DEVSWITCH_EVENT="+hc.Event,
+		"DEVSWITCH_OLD_PROFILE="+hc.OldProfile,
+		"DEVSWITCH_NEW_PROFILE="+hc.NewProfile,
+	)
+	var out bytes.Buffer
+	cmd.Stdout = io.MultiWriter(os.Stdout, &out)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &out)
+	// do not wait for background children holding the pipes
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	res.Duration = time.Since(res.Time).Round(time.Millisecond).String()
+	res.Output = out.String()
+	if len(res.Output) > hookOutputLimit {
+		res.Output = res.Output[:hookOutputLimit] + "\n[truncated]"
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		res.ExitCode, res.Error = -1, fmt.Sprintf("timed out after %s", h.Timeout)
+	case errors.As(err, &exitErr):
+		res.ExitCode, res.Error = exitErr.ExitCode(), fmt.Sprintf("exited with %d", exitErr.ExitCode())
+	case err != nil:
+		res.ExitCode, res.Error = -1, err.Error()
+	}
+	return res
+}
+
+// hookVetoError is returned when a pre-event hook cancels an operation.
+type hookVetoError struct {
+	Event  string
+	Hook   string
+	Reason string
+}
+
+func (e *hookVetoError) Error() string {
+	return fmt.Sprintf("%s hook %s %s", e.Event, e.Hook, e.Reason)
+}
+
+// runHooks runs the hooks of profile for hc.Event and records every run in
+// the operation log. A
+// failing hook of a pre-event stops the remaining hooks and returns an
+// error that cancels the operation. A profile that no longer exists has no
+// hooks, so it cannot block a rollback.
+func runHooks(profile string, hc *hookContext, timeout time.Duration) ([]hookResult, error) {
+	if profile == "" || profileExists(profile) != nil {
+		return nil, nil
+	}
+	hooks, err := profileHooks(profile, hc.Event, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var results []hookResult
+	var failed []string
+	for _, h := range hooks {
+		color.Blue("🪝 Running %s hook %s (%s)...", hc.Event, h.Name, h.Profile)
+		res := h.run(hc)
+		results = append(results, res)
+		noteHook(res)
+		if res.Error == "" {
+			continue
+		}
+		if isPreEvent(hc.Event) {
+			return results, &hookVetoError{Event: hc.Event, Hook: h.Name, Reason: res.Error}
+		}
+		failed = append(failed, fmt.Sprintf("%s %s", h.Name, res.Error))
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%s hooks failed: %s", hc.Event, strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+// changedTargets lists the config files whose content would change when the
+// files in srcDir are copied over them.
+func changedTargets(srcDir string, allowed map[string]bool) []changedFile {
+	var changed []changedFile
+	for _, cfg := range detectConfigFiles() {
+		if allowed != nil && !allowed[cfg.Name] {
+			continue
+		}
+		src := filepath.Join(srcDir, cfg.Name)
+		srcHash, err := getFileHash(src)
+		if err != nil {
+			continue
+		}
+		if dstHash, err := getFileHash(cfg.Src()); err == nil && dstHash == srcHash {
+			continue
+		}
+		changed = append(changed, changedFile{Name: cfg.Name, Path: cfg.Src()})
+	}
+	return changed
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stripJSONC turns JSON with comments and trailing commas, as used by VSCode
+// settings, into plain JSON. String contents are left untouched and line
+// numbers are preserved for error messages.
+func stripJSONC(data []byte) []byte {
+	return dropTrailingCommas(dropComments(data))
+}
+
+// dropComments removes // and /* */ comments outside string literals.
+func dropComments(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		if inString {
+			out = append(out, ch)
+			if ch == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if ch == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case ch == '"':
+			inString = true
+			out = append(out, ch)
+		case ch == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i+1 < len(data) && data[i+1] != '\n' {
+				i++
+			}
+		case ch == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i < len(data) && !(data[i] == '*' && i+1 < len(data) && data[i+1] == '/') {
+				if data[i] == '\n' {
+					out = append(out, '\n')
+				}
+				i++
+			}
+			i++
+		default:
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// dropTrailingCommas removes commas directly before a closing } or ].
+func dropTrailingCommas(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		if inString {
+			out = append(out, ch)
+			if ch == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if ch == '"' {
+				inString = false
+			}
+			continue
+		}
+		if ch == '"' {
+			inString = true
+		}
+		if ch == ',' {
+			j := i + 1
+			for j < len(data) && (data[j] == ' ' || data[j] == '\t' || data[j] == '\n' || data[j] == '\r') {
+				j++
+			}
+			if j < len(data) && (data[j] == '}' || data[j] == ']') {
+				continue
+			}
+		}
+		out = append(out, ch)
+	}
+	return out
+}
+
+// jsoncMember is a key of the top-level object of a JSONC document and the
+// byte range of its value.
+type jsoncMember struct {
+	key        string
+	keyAt      int
+	start, end int
+}
+
+// jsoncObject locates the members of the top-level object, the index of
+// its closing brace and the end of the last token before that brace.
+func jsoncObject(data []byte) (members []jsoncMember, closeAt, lastTok int, err error) {
+	depth := 0
+	inValue := false
+	var cur jsoncMember
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			continue
+		case ch == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i+1 < len(data) && data[i+1] != '\n' {
+				i++
+			}
+			continue
+		case ch == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i < len(data) && !(data[i] == '*' && i+1 < len(data) && data[i+1] == '/') {
+				i++
+			}
+			i++
+			continue
+		}
+
+		if depth == 1 && inValue && cur.start < 0 {
+			cur.start = i
+		}
+		end := i + 1
+		switch ch {
+		case '"':
+			j := i + 1
+			for j < len(data) && data[j] != '"' {
+				if data[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			end = j + 1
+			if depth == 1 && !inValue {
+				cur = jsoncMember{keyAt: i, start: -1}
+				if err := json.Unmarshal(data[i:min(end, len(data))], &cur.key); err != nil {
+					return nil, 0, 0, err
+				}
+			}
+			i = j
+		case '{', '[':
+			if depth == 0 && ch != '{' {
+				return nil, 0, 0, fmt.Errorf("not a JSON object")
+			}
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				if inValue {
+					cur.end = lastTok
+					members = append(members, cur)
+				}
+				return members, i, lastTok, nil
+			}
+		case ':':
+			if depth == 1 {
+				inValue = true
+			}
+		case ',':
+			if depth == 1 && inValue {
+				cur.end = lastTok
+				members = append(members, cur)
+				inValue = false
+			}
+		}
+		if depth == 0 {
+			return nil, 0, 0, fmt.Errorf("not a JSON object")
+		}
+		lastTok = end
+	}
+	return nil, 0, 0, fmt.Errorf("unexpected end of JSON input")
+}
+
+// lineIndent returns the whitespace that starts the line containing pos.
+func lineIndent(data []byte, pos int) string {
+	start := bytes.LastIndexByte(data[:pos], '\n') + 1
+	end := start
+	for end < pos && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+func marshalJSONC(v interface{}, prefix, indent string) (string, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent(prefix, indent)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// setJSONCKeys sets top-level keys of a JSONC object in place: comments,
+// formatting and the order of existing keys are kept, new keys are added
+// at the end of the object.
+func setJSONCKeys(data []byte, values map[string]interface{}) ([]byte, error) {
+	if len(bytes.TrimSpace(stripJSONC(data))) == 0 {
+		data = []byte("{}\n")
+	}
+	var check map[string]interface{}
+	if err := json.Unmarshal(stripJSONC(data), &check); err != nil {
+		return nil, err
+	}
+	members, closeAt, lastTok, err := jsoncObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	indent := "    "
+	if len(members) > 0 {
+		if ind := lineIndent(data, members[0].keyAt); ind != "" {
+			indent = ind
+		}
+	}
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	present := map[string]bool{}
+	for _, m := range members {
+		v, ok := values[m.key]
+		present[m.key] = true
+		if !ok {
+			continue
+		}
+		text, err := marshalJSONC(v, lineIndent(data, m.keyAt), indent)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit{m.start, m.end, text})
+	}
+
+	var added []string
+	for _, k := range sortedKeys(values) {
+		if present[k] {
+			continue
+		}
+		key, _ := marshalJSONC(k, "", "")
+		value, err := marshalJSONC(values[k], indent, indent)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, indent+key+": "+value)
+	}
+	if len(added) > 0 {
+		comma := ""
+		if len(members) > 0 && data[lastTok-1] != ',' {
+			comma = ","
+		}
+		lineStart := bytes.LastIndexByte(data[:closeAt], '\n') + 1
+		if lineStart > lastTok && strings.TrimSpace(string(data[lineStart:closeAt])) == "" {
+			// the closing brace has a line of its own
+			edits = append(edits, edit{lineStart, lineStart, strings.Join(added, ",\n") + "\n"})
+			if comma != "" {
+				edits = append(edits, edit{lastTok, lastTok, comma})
+			}
+		} else {
+			edits = append(edits, edit{closeAt, closeAt, comma + "\n" + strings.Join(added, ",\n") + "\n"})
+		}
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := append([]byte(nil), data...)
+	for _, e := range edits {
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+	return out, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestSetJSONCKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		values map[string]interface{}
+		want   string
+	}{
+		{
+			name: "comments and order are kept",
+			in: `{
+    // font
+    "editor.fontSize": 12,
+    /* theme */
+    "workbench.colorTheme": "Dark"
+}
+`,
+			values: map[string]interface{}{"editor.fontSize": 14},
+			want: `{
+    // font
+    "editor.fontSize": 14,
+    /* theme */
+    "workbench.colorTheme": "Dark"
+}
+`,
+		},
+		{
+			name:   "new key after a trailing comma",
+			in:     "{\n    \"a\": 1,\n}\n",
+			values: map[string]interface{}{"b": true},
+			want:   "{\n    \"a\": 1,\n    \"b\": true\n}\n",
+		},
+		{
+			name:   "new key takes the file's indent",
+			in:     "{\n  \"a\": 1\n}",
+			values: map[string]interface{}{"b": "x"},
+			want:   "{\n  \"a\": 1,\n  \"b\": \"x\"\n}",
+		},
+		{
+			name:   "nested keys of the same name are left alone",
+			in:     "{\n    \"x\": {\n        \"a\": 1\n    },\n    \"a\": 2\n}\n",
+			values: map[string]interface{}{"a": 3},
+			want:   "{\n    \"x\": {\n        \"a\": 1\n    },\n    \"a\": 3\n}\n",
+		},
+		{
+			name:   "object values are replaced whole",
+			in:     "{\n    \"x\": {\n        \"a\": 1\n    },\n    \"a\": 2\n}\n",
+			values: map[string]interface{}{"x": map[string]interface{}{"b": 2}},
+			want:   "{\n    \"x\": {\n        \"b\": 2\n    },\n    \"a\": 2\n}\n",
+		},
+		{
+			name:   "comment markers and braces inside strings",
+			in:     `{"url": "http://x/}", "a": 1}`,
+			values: map[string]interface{}{"a": 2},
+			want:   `{"url": "http://x/}", "a": 2}`,
+		},
+		{
+			name:   "empty file",
+			in:     "",
+			values: map[string]interface{}{"a": 1},
+			want:   "{\n    \"a\": 1\n}\n",
+		},
+		{
+			name:   "html characters are not escaped",
+			in:     "{}",
+			values: map[string]interface{}{"a": "<&>"},
+			want:   "{\n    \"a\": \"<&>\"\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setJSONCKeys([]byte(tt.in), tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetJSONCKeysRejectsInvalidInput(t *testing.T) {
+	for _, in := range []string{`[1, 2]`, `{"a": }`, `{"a": 1`} {
+		if _, err := setJSONCKeys([]byte(in), map[string]interface{}{"a": 2}); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
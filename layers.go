@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// How a file is combined when several layers of a profile provide it.
+const (
+	mergeReplace = "replace" // the last layer providing the file wins
+	mergeAppend  = "append"  // contents are concatenated in layer order
+	mergeMerge   = "merge"   // structured merge, later keys win
+)
+
+// profileLayers returns the layers of a profile in application order: the
+// layers of each extended profile, left to right, followed by the profile
+// itself. A profile reached twice is only applied at its first position.
+func profileLayers(name string) ([]string, error) {
+	var layers []string
+	seen := map[string]bool{}
+	var visit func(name string, stack []string) error
+	visit = func(name string, stack []string) error {
+		for _, s := range stack {
+			if s == name {
+				return fmt.Errorf("profile inheritance cycle: %s", strings.Join(append(stack, name), " → "))
+			}
+		}
+		if err := checkName("profile", name); err != nil {
+			if len(stack) > 0 {
+				return fmt.Errorf("profile %s extends %q: %v", stack[len(stack)-1], name, err)
+			}
+			return err
+		}
+		profPath := filepath.Join(profilesDir(), name)
+		if _, err := os.Stat(profPath); err != nil {
+			if len(stack) > 0 {
+				return fmt.Errorf("profile %s extends %s, which does not exist", stack[len(stack)-1], name)
+			}
+			return fmt.Errorf("profile %s does not exist", name)
+		}
+		m, err := loadManifest(profPath)
+		if err != nil {
+			return err
+		}
+		for _, parent := range m.Extends {
+			if err := visit(parent, append(stack, name)); err != nil {
+				return err
+			}
+		}
+		if !seen[name] {
+			seen[name] = true
+			layers = append(layers, name)
+		}
+		return nil
+	}
+	if err := visit(name, nil); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// mergeMaps deep-merges src into dst. Nested maps are merged, any other
+// value, lists included, is replaced.
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				mergeMaps(dm, sm)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// resolveManifest merges the manifests of all layers. Extends is not
+// inherited; it always reflects the profile itself.
+func resolveManifest(layers []string) (*profileManifest, error) {
+	merged := map[string]interface{}{}
+	for _, layer := range layers {
+		data, err := os.ReadFile(filepath.Join(profilesDir(), layer, manifestName))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		raw := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid %s in %s: %v", manifestName, layer, err)
+		}
+		mergeMaps(merged, raw)
+	}
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	m := &profileManifest{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	own, err := loadManifest(filepath.Join(profilesDir(), layers[len(layers)-1]))
+	if err != nil {
+		return nil, err
+	}
+	m.Extends = own.Extends
+	return m, nil
+}
+
+// resolvedFile is the effective content of one profile file.
+type resolvedFile struct {
+	Name    string
+	Mode    string
+	Sources []string // layers that provide the file, in order
+	Content []byte
+	Perm    os.FileMode
+}
+
+// resolvedProfile is a profile with all of its layers applied.
+type resolvedProfile struct {
+	Name     string
+	Layers   []string
+	Manifest *profileManifest
+	Files    []*resolvedFile
+}
+
+// layerFiles lists the profile files in a layer directory. The manifest and
+// subdirectories are not profile files.
+func layerFiles(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.DirEntry
+	for _, e := range entries {
+		if e.Type().IsRegular() && e.Name() != manifestName {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+func resolveProfile(name string) (*resolvedProfile, error) {
+	layers, err := profileLayers(name)
+	if err != nil {
+		return nil, err
+	}
+	m, err := resolveManifest(layers)
+	if err != nil {
+		return nil, err
+	}
+	rp := &resolvedProfile{Name: name, Layers: layers, Manifest: m}
+
+	vars, err := templateVars(name, m)
+	if err != nil {
+		return nil, err
+	}
+	data := newTemplateData(name, vars)
+
+	byName := map[string]*resolvedFile{}
+	for _, layer := range layers {
+		dir := filepath.Join(profilesDir(), layer)
+		entries, err := layerFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			content, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			// templates are rendered per layer, so merges see the final text
+			fileName := e.Name()
+			if strings.HasSuffix(fileName, templateSuffix) {
+				fileName = strings.TrimSuffix(fileName, templateSuffix)
+				content, err = renderTemplate(e.Name(), content, data)
+				if err != nil {
+					return nil, fmt.Errorf("profile %s: %v", layer, err)
+				}
+			}
+			rf, ok := byName[fileName]
+			if !ok {
+				mode := m.Merge[fileName]
+				switch mode {
+				case "":
+					mode = mergeReplace
+				case mergeReplace, mergeAppend, mergeMerge:
+				default:
+					return nil, fmt.Errorf("%s: unknown merge mode %q, expected replace, append or merge", fileName, mode)
+				}
+				rf = &resolvedFile{Name: fileName, Mode: mode}
+				byName[fileName] = rf
+				rp.Files = append(rp.Files, rf)
+			}
+			if err := rf.add(layer, content); err != nil {
+				return nil, fmt.Errorf("%s: %v", fileName, err)
+			}
+			rf.Perm = info.Mode().Perm()
+		}
+	}
+	sort.Slice(rp.Files, func(i, j int) bool { return rp.Files[i].Name < rp.Files[j].Name })
+	return rp, nil
+}
+
+// add layers data from the given profile on top of the file's content.
+func (rf *resolvedFile) add(layer string, data []byte) error {
+	rf.Sources = append(rf.Sources, layer)
+	if len(rf.Sources) == 1 || rf.Mode == mergeReplace {
+		rf.Content = data
+		return nil
+	}
+	if rf.Mode == mergeAppend {
+		content := rf.Content
+		if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+			content = append(content, '\n')
+		}
+		rf.Content = append(content, data...)
+		return nil
+	}
+	merged, err := mergeFileContent(rf.Name, rf.Content, data)
+	if err != nil {
+		return err
+	}
+	rf.Content = merged
+	return nil
+}
+
+// mergeFileContent merges two versions of a profile file key by key, with
+// overlay winning. The format is chosen from the file name.
+func mergeFileContent(name string, base, overlay []byte) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		return mergeJSON(base, overlay)
+	case name == ".gitconfig" || name == "aws_config" || name == "aws_credentials":
+		return mergeINI(base, overlay), nil
+	case name == ".env" || name == ".npmrc" || name == ".yarnrc":
+		return mergeKeyValue(base, overlay), nil
+	case name == "kube_config" || strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml"):
+		return mergeYAML(base, overlay)
+	}
+	return nil, fmt.Errorf("merge is not supported for this file, use replace or append")
+}
+
+func mergeJSON(base, overlay []byte) ([]byte, error) {
+	dst := map[string]interface{}{}
+	src := map[string]interface{}{}
+	if err := json.Unmarshal(stripJSONC(base), &dst); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(stripJSONC(overlay), &src); err != nil {
+		return nil, err
+	}
+	mergeMaps(dst, src)
+	out, err := json.MarshalIndent(dst, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func mergeYAML(base, overlay []byte) ([]byte, error) {
+	dst := map[string]interface{}{}
+	src := map[string]interface{}{}
+	if err := yaml.Unmarshal(base, &dst); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(overlay, &src); err != nil {
+		return nil, err
+	}
+	mergeMaps(dst, src)
+	return yaml.Marshal(dst)
+}
+
+// splitKeyValue returns the key assigned on a KEY=VALUE or INI line, or ""
+// for comments, blank lines and anything else.
+func splitKeyValue(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+		return ""
+	}
+	key, _, ok := strings.Cut(strings.TrimPrefix(trimmed, "export "), "=")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
+func readLines(data []byte) []string {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
+
+// mergeKeyValueLines overrides assignments in base with those in overlay
+// and appends the keys base does not have.
+func mergeKeyValueLines(base, overlay []string) []string {
+	index := map[string]int{}
+	out := append([]string(nil), base...)
+	for i, line := range out {
+		if key := splitKeyValue(line); key != "" {
+			index[key] = i
+		}
+	}
+	for _, line := range overlay {
+		key := splitKeyValue(line)
+		if i, ok := index[key]; ok && key != "" {
+			out[i] = line
+			continue
+		}
+		if key == "" && strings.TrimSpace(line) == "" {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func mergeKeyValue(base, overlay []byte) []byte {
+	lines := mergeKeyValueLines(readLines(base), readLines(overlay))
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// iniSections splits an INI file into its sections. Lines before the first
+// header belong to the section "".
+func iniSections(data []byte) ([]string, map[string][]string) {
+	var order []string
+	sections := map[string][]string{}
+	current := ""
+	order = append(order, current)
+	for _, line := range readLines(data) {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = trimmed
+			if _, ok := sections[current]; !ok {
+				order = append(order, current)
+			}
+			continue
+		}
+		sections[current] = append(sections[current], line)
+	}
+	return order, sections
+}
+
+func mergeINI(base, overlay []byte) []byte {
+	order, sections := iniSections(base)
+	overOrder, overSections := iniSections(overlay)
+	for _, name := range overOrder {
+		if _, ok := sections[name]; !ok {
+			order = append(order, name)
+		}
+		sections[name] = mergeKeyValueLines(sections[name], overSections[name])
+	}
+
+	var b strings.Builder
+	for _, name := range order {
+		if name != "" {
+			b.WriteString(name + "\n")
+		}
+		for _, line := range sections[name] {
+			b.WriteString(line + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+func buildDir() string {
+	return filepath.Join(devDir(), "build")
+}
+
+// profileSourceDir returns the directory holding the effective files of a
+// profile. Plain profiles are used in place; layered, templated and composed
+// ones are materialised into the build directory first.
+func profileSourceDir(name string) (string, error) {
+	if isComposite(name) {
+		comp, err := compositionFor(name)
+		if err != nil {
+			return "", err
+		}
+		return materializeComposition(comp)
+	}
+	profPath := filepath.Join(profilesDir(), name)
+	m, err := loadManifest(profPath)
+	if err != nil {
+		return "", err
+	}
+	if len(m.Extends) == 0 && !hasTemplates(profPath) {
+		return profPath, nil
+	}
+	rp, err := resolveProfile(name)
+	if err != nil {
+		return "", err
+	}
+	return publishBuild(name, rp.Files)
+}
+
+// buildCurrent reports whether dir holds exactly files.
+func buildCurrent(dir string, files []*resolvedFile) bool {
+	entries, err := layerFiles(dir)
+	if err != nil || len(entries) != len(files) {
+		return false
+	}
+	for _, rf := range files {
+		info, err := os.Stat(filepath.Join(dir, rf.Name))
+		if err != nil || info.Mode().Perm() != rf.Perm {
+			return false
+		}
+		data, err := os.ReadFile(filepath.Join(dir, rf.Name))
+		if err != nil || !bytes.Equal(data, rf.Content) {
+			return false
+		}
+	}
+	return true
+}
+
+// publishBuild makes files the content of build/<name> and returns its path.
+// Nothing is written while the build is current, which is the common case
+// for the prompt hooks. Otherwise the files go into a new directory and the
+// build/<name> symlink is switched to it with one rename, so paths into the
+// build, like those exported by sessions, never stop existing.
+func publishBuild(name string, files []*resolvedFile) (string, error) {
+	dst := filepath.Join(buildDir(), name)
+	if buildCurrent(dst, files) {
+		return dst, nil
+	}
+	if err := os.MkdirAll(buildDir(), 0o700); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(buildDir(), "."+name+"-")
+	if err != nil {
+		return "", err
+	}
+	for _, rf := range files {
+		if err := os.WriteFile(filepath.Join(tmp, rf.Name), rf.Content, rf.Perm); err != nil {
+			os.RemoveAll(tmp)
+			return "", err
+		}
+	}
+
+	old, _ := os.Readlink(dst)
+	if info, err := os.Lstat(dst); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		// a build directory from before builds were linked
+		if err := os.RemoveAll(dst); err != nil {
+			os.RemoveAll(tmp)
+			return "", err
+		}
+	}
+	link := tmp + ".link"
+	if err := os.Symlink(filepath.Base(tmp), link); err != nil {
+		// no symlinks on this system: replace the directory instead
+		os.RemoveAll(dst)
+		return dst, os.Rename(tmp, dst)
+	}
+	if err := os.Rename(link, dst); err != nil {
+		os.Remove(link)
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	if old != "" {
+		os.RemoveAll(filepath.Join(buildDir(), filepath.Base(old)))
+	}
+	return dst, nil
+}
+
+// removeBuild deletes the build of a profile, if it has one.
+func removeBuild(name string) {
+	dst := filepath.Join(buildDir(), name)
+	if target, err := os.Readlink(dst); err == nil {
+		os.RemoveAll(filepath.Join(buildDir(), filepath.Base(target)))
+	}
+	os.RemoveAll(dst)
+}
+
+// loadResolvedManifest returns the manifest of a profile with the manifests
+// of the profiles it extends merged in. Composed names combine their members.
+func loadResolvedManifest(name string) (*profileManifest, error) {
+	if isComposite(name) {
+		return composedManifest(splitProfileSpec(name))
+	}
+	layers, err := profileLayers(name)
+	if err != nil {
+		return nil, err
+	}
+	return resolveManifest(layers)
+}
+
+func cmdShow(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return fmt.Errorf("profile name required")
+	}
+	name := c.Args().First()
+	rp, err := resolveProfile(name)
+	if err != nil {
+		return err
+	}
+
+	info := fmt.Sprintf("Layers: %s\n\n", strings.Join(rp.Layers, " → "))
+	if !c.Bool("resolved") {
+		details, err := showDetails(name)
+		if err != nil {
+			return err
+		}
+		files, err := fileInventory(filepath.Join(profilesDir(), name))
+		if err != nil {
+			return err
+		}
+		boxInfo("Profile "+name, details+"\n"+info+"Files:\n"+strings.TrimRight(files, "\n"))
+		return nil
+	}
+
+	info += "Files:\n"
+	for _, rf := range rp.Files {
+		last := rf.Sources[len(rf.Sources)-1]
+		from := last
+		switch {
+		case len(rf.Sources) == 1:
+		case rf.Mode == mergeReplace:
+			from = fmt.Sprintf("%s (overrides %s)", last, strings.Join(rf.Sources[:len(rf.Sources)-1], ", "))
+		default:
+			from = strings.Join(rf.Sources, " + ")
+		}
+		info += fmt.Sprintf("  • %-20s %-8s %s\n", rf.Name, rf.Mode, from)
+	}
+	boxInfo("Profile "+name+" (resolved)", info)
+
+	if c.Bool("content") {
+		for _, rf := range rp.Files {
+			color.Blue("── %s ──", rf.Name)
+			fmt.Println(strings.TrimRight(string(rf.Content), "\n"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvedFileAdd(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		mode   string
+		layers []string
+		want   string
+	}{
+		{
+			name:   "replace keeps the last layer",
+			file:   ".zshrc",
+			mode:   mergeReplace,
+			layers: []string{"base\n", "top\n"},
+			want:   "top\n",
+		},
+		{
+			name:   "append adds a missing newline between layers",
+			file:   ".zshrc",
+			mode:   mergeAppend,
+			layers: []string{"alias a=1", "alias b=2\n"},
+			want:   "alias a=1\nalias b=2\n",
+		},
+		{
+			name:   "json merges nested objects and replaces lists",
+			file:   "settings.json",
+			mode:   mergeMerge,
+			layers: []string{"{\n  // base\n  \"a\": 1,\n  \"n\": {\"x\": 1, \"y\": 2},\n  \"l\": [1, 2],\n}", `{"n": {"y": 3}, "l": [3], "b": true}`},
+			want:   "{\n    \"a\": 1,\n    \"b\": true,\n    \"l\": [\n        3\n    ],\n    \"n\": {\n        \"x\": 1,\n        \"y\": 3\n    }\n}\n",
+		},
+		{
+			name:   "yaml merges nested maps",
+			file:   "kube_config",
+			mode:   mergeMerge,
+			layers: []string{"a: 1\nm:\n  p: 1\n  q: 2\n", "m:\n  q: 3\nb: [1]\n"},
+			want:   "a: 1\nb:\n    - 1\nm:\n    p: 1\n    q: 3\n",
+		},
+		{
+			name:   "key value files override in place and append new keys",
+			file:   ".env",
+			mode:   mergeMerge,
+			layers: []string{"# shared\nA=1\nexport B=2\nURL=http://x?a=b\n", "B=3\n\nURL=http://y?c=d\nC=4\n"},
+			want:   "# shared\nA=1\nB=3\nURL=http://y?c=d\nC=4\n",
+		},
+		{
+			name:   "ini merges per section",
+			file:   ".gitconfig",
+			mode:   mergeMerge,
+			layers: []string{"[user]\n\tname = a\n\temail = a@x\n[core]\n\teditor = vim\n", "[user]\n\temail = b@x\n[alias]\n\tco = checkout\n"},
+			want:   "[user]\n\tname = a\n\temail = b@x\n[core]\n\teditor = vim\n[alias]\n\tco = checkout\n",
+		},
+		{
+			name:   "three layers merge in order",
+			file:   ".npmrc",
+			mode:   mergeMerge,
+			layers: []string{"a=1\nb=1\n", "b=2\n", "a=3\n"},
+			want:   "a=3\nb=2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := &resolvedFile{Name: tt.file, Mode: tt.mode}
+			for i, layer := range tt.layers {
+				if err := rf.add(string(rune('a'+i)), []byte(layer)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(rf.Content) != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", rf.Content, tt.want)
+			}
+			if len(rf.Sources) != len(tt.layers) {
+				t.Errorf("sources %v, want %d layers", rf.Sources, len(tt.layers))
+			}
+		})
+	}
+}
+
+func TestMergeFileContentErrors(t *testing.T) {
+	tests := []struct {
+		file, base, overlay string
+	}{
+		{".zshrc", "a\n", "b\n"},
+		{"settings.json", `{"a": }`, `{}`},
+		{"docker_config.json", `{}`, `[1]`},
+		{"kube_config", "a: [", "b: 1\n"},
+	}
+	for _, tt := range tests {
+		if _, err := mergeFileContent(tt.file, []byte(tt.base), []byte(tt.overlay)); err == nil {
+			t.Errorf("%s: expected an error merging %q and %q", tt.file, tt.base, tt.overlay)
+		}
+	}
+}
+
+func TestPublishBuildKeepsPathsValid(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	files := []*resolvedFile{{Name: ".gitconfig", Content: []byte("[user]\n\tname = a\n"), Perm: 0o644}}
+	dst, err := publishBuild("work", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dst, ".gitconfig")
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an unchanged build is not rewritten
+	if _, err := publishBuild("work", files); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("unchanged build was rewritten")
+	}
+
+	// a changed build replaces the content behind the same path
+	files[0].Content = []byte("[user]\n\tname = b\n")
+	if _, err := publishBuild("work", files); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(files[0].Content) {
+		t.Errorf("build holds %q, want %q", data, files[0].Content)
+	}
+	entries, err := os.ReadDir(buildDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("build directory holds %d entries, want the link and one build", len(entries))
+	}
+
+	removeBuild("work")
+	if entries, _ := os.ReadDir(buildDir()); len(entries) != 0 {
+		t.Errorf("removeBuild left %d entries", len(entries))
+	}
+}
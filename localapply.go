@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// localGitSettings is the `git` section of a local config. Values are
+// written to the repository's .git/config.
+type localGitSettings struct {
+	User    map[string]string            `yaml:"user,omitempty"`    // name, email, signingkey
+	Remotes map[string]map[string]string `yaml:"remotes,omitempty"` // remote -> url, pushurl, ...
+	Config  map[string]string            `yaml:"config,omitempty"`  // any other key, e.g. core.sshCommand
+}
+
+type localVSCodeSettings struct {
+	Settings map[string]interface{} `yaml:"settings,omitempty"`
+}
+
+// localProvider writes one kind of project-level config. Files lists the
+// paths it may change, relative to the repository root unless they lie
+// outside it, so they can be backed up first.
+type localProvider struct {
+	Name  string
+	Files func(root string, lc *localConfig) ([]string, error)
+	Apply func(root string, lc *localConfig) error
+}
+
+var localProviders = []localProvider{
+	{
+		Name: "git",
+		Files: func(root string, lc *localConfig) ([]string, error) {
+			if len(lc.gitValues()) == 0 {
+				return nil, nil
+			}
+			path, err := gitPath(root, "config")
+			if err != nil {
+				return nil, err
+			}
+			if rel, err := filepath.Rel(root, path); err == nil && isWithin(root, path) {
+				return []string{rel}, nil
+			}
+			return []string{path}, nil
+		},
+		Apply: applyLocalGit,
+	},
+	{
+		Name: "vscode",
+		Files: func(root string, lc *localConfig) ([]string, error) {
+			if len(lc.VSCode.Settings) == 0 {
+				return nil, nil
+			}
+			return []string{filepath.Join(".vscode", "settings.json")}, nil
+		},
+		Apply: applyLocalVSCode,
+	},
+	{
+		Name: "env",
+		Files: func(root string, lc *localConfig) ([]string, error) {
+			var files []string
+			if len(lc.Env) > 0 {
+				files = append(files, ".env")
+			}
+			if len(lc.Envrc) > 0 {
+				files = append(files, ".envrc")
+			}
+			return files, nil
+		},
+		Apply: applyLocalEnv,
+	},
+	{
+		Name: "npmrc",
+		Files: func(root string, lc *localConfig) ([]string, error) {
+			if len(lc.Npmrc) == 0 {
+				return nil, nil
+			}
+			return []string{".npmrc"}, nil
+		},
+		Apply: func(root string, lc *localConfig) error {
+			return updateKeyValueFile(filepath.Join(root, ".npmrc"), lc.Npmrc, func(k, v string) string {
+				return k + "=" + v
+			})
+		},
+	},
+}
+
+// gitValues flattens the git section into git config keys.
+func (lc *localConfig) gitValues() map[string]string {
+	values := map[string]string{}
+	for k, v := range lc.Git.Config {
+		values[k] = v
+	}
+	for k, v := range lc.Git.User {
+		values["user."+k] = v
+	}
+	for remote, settings := range lc.Git.Remotes {
+		for k, v := range settings {
+			values["remote."+remote+"."+k] = v
+		}
+	}
+	return values
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func applyLocalGit(root string, lc *localConfig) error {
+	values := lc.gitValues()
+	for _, key := range sortedKeys(values) {
+		out, err := exec.Command("git", "-C", root, "config", "--local", key, values[key]).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git config %s: %v: %s", key, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// applyLocalVSCode sets the keys in the workspace settings in place, so the
+// user's comments and key order survive.
+func applyLocalVSCode(root string, lc *localConfig) error {
+	path := filepath.Join(root, ".vscode", "settings.json")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err = setJSONCKeys(data, lc.VSCode.Settings)
+	if err != nil {
+		return fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func applyLocalEnv(root string, lc *localConfig) error {
+	// .envrc is sourced by the shell, so names must not carry code
+	for _, values := range []map[string]string{lc.Env, lc.Envrc} {
+		for k := range values {
+			if !envKeyPattern.MatchString(k) {
+				return fmt.Errorf("invalid variable name %q", k)
+			}
+		}
+	}
+	if len(lc.Env) > 0 {
+		if err := updateKeyValueFile(filepath.Join(root, ".env"), lc.Env, func(k, v string) string {
+			return k + "=" + dotenvQuote(v)
+		}); err != nil {
+			return err
+		}
+	}
+	if len(lc.Envrc) > 0 {
+		return updateKeyValueFile(filepath.Join(root, ".envrc"), lc.Envrc, func(k, v string) string {
+			return "export " + k + "=" + shellQuote("bash", v)
+		})
+	}
+	return nil
+}
+
+// dotenvQuote quotes a .env value when it holds anything a dotenv parser
+// would otherwise cut or interpret: spaces, comments, quotes or escapes.
+// Single quotes keep the value literal; values containing one are double
+// quoted with backslash escapes.
+func dotenvQuote(v string) string {
+	if v == "" || !strings.ContainsAny(v, " \t\n\r#\"'\\$`=") {
+		return v
+	}
+	if !strings.ContainsAny(v, "'\n\r") {
+		return "'" + v + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`, "`", "\\`")
+	return `"` + r.Replace(v) + `"`
+}
+
+// updateKeyValueFile sets keys in a KEY=VALUE style file, replacing existing
+// assignments in place and appending new ones. Other lines are kept.
+func updateKeyValueFile(path string, values map[string]string, format func(k, v string) string) error {
+	var lines []string
+	if data, err := os.ReadFile(path); err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	done := map[string]bool{}
+	for i, line := range lines {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "export ")
+		key, _, ok := strings.Cut(trimmed, "=")
+		key = strings.TrimSpace(key)
+		if v, want := values[key]; ok && want {
+			lines[i] = format(key, v)
+			done[key] = true
+		}
+	}
+	for _, key := range sortedKeys(values) {
+		if !done[key] {
+			lines = append(lines, format(key, values[key]))
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
+
+// localBackupEntry records one file of a project-level backup. Files that
+// did not exist are removed again on rollback. Stored is the copy inside the
+// backup; older backups kept it at files/<path>.
+type localBackupEntry struct {
+	Path    string `json:"path"`
+	Stored  string `json:"stored,omitempty"`
+	Existed bool   `json:"existed"`
+}
+
+func (e localBackupEntry) stored() string {
+	if e.Stored != "" {
+		return e.Stored
+	}
+	return filepath.Join("files", e.Path)
+}
+
+// gitPath returns the path of name inside the repository's git directory.
+// In worktrees and submodules .git is a file pointing elsewhere, so git is
+// asked; without git only a plain .git directory is understood.
+func gitPath(root, name string) (string, error) {
+	out, err := exec.Command("git", "-C", root, "rev-parse", "--git-path", name).Output()
+	if err != nil {
+		if info, serr := os.Stat(filepath.Join(root, ".git")); serr == nil && info.IsDir() {
+			return filepath.Join(root, ".git", name), nil
+		}
+		return "", fmt.Errorf("cannot locate the git directory of %s: %v", root, err)
+	}
+	path := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	return path, nil
+}
+
+func localBackupsDir(root string) (string, error) {
+	return gitPath(root, filepath.Join("devswitch", "backups"))
+}
+
+// localBackupStamp names project backups by the time they were taken.
+const localBackupStamp = "20060102-150405"
+
+// newLocalBackupDir creates a backup directory named after the current
+// time, with a counter when several backups are taken within a second.
+func newLocalBackupDir(root string) (string, error) {
+	base, err := localBackupsDir(root)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		return "", err
+	}
+	ts := time.Now().Format(localBackupStamp)
+	for n := 1; ; n++ {
+		name := ts
+		if n > 1 {
+			name = fmt.Sprintf("%s-%02d", ts, n)
+		}
+		dir := filepath.Join(base, name)
+		if err := os.Mkdir(dir, 0o755); err == nil {
+			return dir, nil
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// newerLocalBackup reports whether backup a was taken after b. Names are a
+// timestamp with an optional -NN counter, which sorts numerically.
+func newerLocalBackup(a, b string) bool {
+	split := func(name string) (string, int) {
+		stamp, n, ok := strings.Cut(name[min(len(name), len(localBackupStamp)):], "-")
+		if seq, err := strconv.Atoi(n); ok && stamp == "" && err == nil {
+			return name[:len(localBackupStamp)], seq
+		}
+		return name, 1
+	}
+	stampA, seqA := split(a)
+	stampB, seqB := split(b)
+	if stampA != stampB {
+		return stampA > stampB
+	}
+	return seqA > seqB
+}
+
+// localFilePath resolves a path listed by a provider.
+func localFilePath(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+func backupLocalFiles(root string, files []string) (string, error) {
+	dir, err := newLocalBackupDir(root)
+	if err != nil {
+		return "", err
+	}
+	var index []localBackupEntry
+	for i, rel := range files {
+		src := localFilePath(root, rel)
+		entry := localBackupEntry{Path: rel, Stored: filepath.Join("files", fmt.Sprintf("%d-%s", i, filepath.Base(rel)))}
+		if _, err := os.Stat(src); err == nil {
+			entry.Existed = true
+			if err := copyFile(src, filepath.Join(dir, entry.Stored)); err != nil {
+				return "", err
+			}
+		}
+		index = append(index, entry)
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return dir, os.WriteFile(filepath.Join(dir, "index.json"), data, 0o644)
+}
+
+// localRepoRoot finds the local config for the working directory and the
+// repository it belongs to.
+func localRepoRoot() (string, string, error) {
+	cfg, err := findLocalConfig("")
+	if err != nil {
+		return "", "", err
+	}
+	root := filepath.Dir(cfg)
+	// .git is a directory, or a file in worktrees and submodules
+	if _, err := os.Stat(filepath.Join(root, ".git")); err != nil {
+		return "", "", fmt.Errorf("%s is not the root of a git repository", root)
+	}
+	return cfg, root, nil
+}
+
+func cmdApplyLocal(c *cli.Context) error {
+	cfg, root, err := localRepoRoot()
+	if err != nil {
+		return err
+	}
+	if err := requireTrusted(cfg); err != nil {
+		return err
+	}
+	lc, err := loadLocalConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, p := range localProviders {
+		pf, err := p.Files(root, lc)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p.Name, err)
+		}
+		files = append(files, pf...)
+	}
+	if len(files) == 0 {
+		boxInfo("Nothing To Apply", fmt.Sprintf("%s has no project settings", cfg))
+		return nil
+	}
+
+	fmt.Printf("%s Creating backup...\n", color.YellowString("⚠️ "))
+	backupDir, err := backupLocalFiles(root, files)
+	if err != nil {
+		return fmt.Errorf("backup failed: %v", err)
+	}
+	color.Green("✅ Backup created in %s", backupDir)
+
+	for _, p := range localProviders {
+		if pf, _ := p.Files(root, lc); len(pf) == 0 {
+			continue
+		}
+		color.Blue("📋 Applying %s settings...", p.Name)
+		if err := p.Apply(root, lc); err != nil {
+			return fmt.Errorf("%s: %v", p.Name, err)
+		}
+	}
+
+	boxInfo("Project Settings Applied", fmt.Sprintf("%s\n\n%s", root, strings.Join(files, "\n")))
+	return nil
+}
+
+func cmdRollbackLocal(c *cli.Context) error {
+	_, root, err := localRepoRoot()
+	if err != nil {
+		return err
+	}
+
+	backupsDir, err := localBackupsDir(root)
+	if err != nil {
+		return err
+	}
+	var backupPath string
+	if c.Args().Len() > 0 {
+		backupPath = filepath.Join(backupsDir, c.Args().First())
+		if _, err := os.Stat(backupPath); err != nil {
+			return fmt.Errorf("backup %s does not exist", c.Args().First())
+		}
+	} else {
+		entries, err := os.ReadDir(backupsDir)
+		if err != nil || len(entries) == 0 {
+			boxInfo("No Backups Found", "No project backups available to rollback to")
+			return nil
+		}
+		latest := ""
+		for _, e := range entries {
+			if e.IsDir() && (latest == "" || newerLocalBackup(e.Name(), latest)) {
+				latest = e.Name()
+			}
+		}
+		if latest == "" {
+			return fmt.Errorf("no project backups in %s", backupsDir)
+		}
+		backupPath = filepath.Join(backupsDir, latest)
+		color.Blue("🔄 Using latest backup: %s", latest)
+	}
+
+	data, err := os.ReadFile(filepath.Join(backupPath, "index.json"))
+	if err != nil {
+		return fmt.Errorf("backup is unreadable: %v", err)
+	}
+	var index []localBackupEntry
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("backup is unreadable: %v", err)
+	}
+
+	for _, e := range index {
+		dst := localFilePath(root, e.Path)
+		if !e.Existed {
+			color.Blue("🗑️  Removing %s...", e.Path)
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		color.Blue("📋 Restoring %s...", e.Path)
+		if err := copyFile(filepath.Join(backupPath, e.stored()), dst); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", e.Path, err)
+		}
+	}
+
+	boxInfo("Rollback Complete", fmt.Sprintf("Restored %d project files in %s", len(index), root))
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestDotenvQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"a b", "'a b'"},
+		{"x#y", "'x#y'"},
+		{"$HOME", "'$HOME'"},
+		{"k=v", "'k=v'"},
+		{`say "hi"`, `'say "hi"'`},
+		{`C:\tmp`, `'C:\tmp'`},
+		{"it's", `"it's"`},
+		{"it's $x", `"it's \$x"`},
+		{"a\nb", `"a\nb"`},
+		{"it's `cmd` \\", "\"it's \\`cmd\\` \\\\\""},
+	}
+	for _, tt := range tests {
+		if got := dotenvQuote(tt.in); got != tt.want {
+			t.Errorf("dotenvQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewerLocalBackup(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"20260101-120001", "20260101-120000", true},
+		{"20260101-120000", "20260101-120001", false},
+		{"20260101-120000-02", "20260101-120000", true},
+		{"20260101-120000-10", "20260101-120000-09", true},
+		{"20260101-120000-100", "20260101-120000-99", true},
+		{"20260101-120000-99", "20260101-120001", false},
+		{"20260101-120000", "20260101-120000", false},
+	}
+	for _, tt := range tests {
+		if got := newerLocalBackup(tt.a, tt.b); got != tt.want {
+			t.Errorf("newerLocalBackup(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
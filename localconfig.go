@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// localConfigNames are the repository-local config files, in order of
+// preference, that bind a directory tree to a profile.
+var localConfigNames = []string{".devswitch.yaml", "devswitch.yaml"}
+
+// localConfig is a repository-local .devswitch.yaml. Profile activates a
+// profile for the tree from the shell integration; the remaining sections
+// are written into the project by `devswitch apply --local`.
+type localConfig struct {
+	Profile string              `yaml:"profile,omitempty"`
+	Git     localGitSettings    `yaml:"git,omitempty"`
+	VSCode  localVSCodeSettings `yaml:"vscode,omitempty"`
+	Env     map[string]string   `yaml:"env,omitempty"`   // entries for .env
+	Envrc   map[string]string   `yaml:"envrc,omitempty"` // exports for .envrc
+	Npmrc   map[string]string   `yaml:"npmrc,omitempty"`
+}
+
+// localConfigIn returns the local config file in dir, or "" if there is
+// none. Profile directories are skipped: their devswitch.yaml is a profile
+// manifest, not a local config.
+func localConfigIn(dir string) string {
+	if isWithin(profilesDir(), dir) {
+		return ""
+	}
+	for _, name := range localConfigNames {
+		p := filepath.Join(dir, name)
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+func loadLocalConfig(path string) (*localConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lc := &localConfig{}
+	if err := yaml.Unmarshal(data, lc); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	return lc, nil
+}
+
+// isWithin reports whether path is root or lies below it.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
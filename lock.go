@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// stateLockWait is how long a command waits for another one to finish
+// changing the applied profile.
+const stateLockWait = 30 * time.Second
+
+// stateLock keeps apply, undo, redo and rollback from running at the same
+// time as each other or as an automatic save or restore by watch. The lock
+// file holds the owner's pid; a lock left by a process that is gone is taken
+// over.
+type stateLock struct {
+	path string
+}
+
+func stateLockPath() string {
+	return filepath.Join(devDir(), "lock")
+}
+
+// heldStateLock is the lock this process holds, so that nested steps of one
+// command do not wait for themselves.
+var heldStateLock *stateLock
+
+// processRunning reports whether a process with the given pid exists.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// lockOwner returns the pid in the lock file, or 0 while the owner has not
+// written it yet.
+func lockOwner(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, nil
+}
+
+// acquireStateLock takes the state lock, waiting up to wait for its owner.
+func acquireStateLock(wait time.Duration) (*stateLock, error) {
+	if heldStateLock != nil {
+		return &stateLock{}, nil
+	}
+	if err := os.MkdirAll(devDir(), 0o755); err != nil {
+		return nil, err
+	}
+	path := stateLockPath()
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			heldStateLock = &stateLock{path: path}
+			return heldStateLock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		pid, err := lockOwner(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		info, _ := os.Stat(path)
+		stale := pid != 0 && !processRunning(pid)
+		if pid == 0 && info != nil && time.Since(info.ModTime()) > 5*time.Second {
+			// the owner died before writing its pid
+			stale = true
+		}
+		if stale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("another devswitch command (pid %d) is changing the active profile; try again when it has finished, or remove %s if it is not running", pid, path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// release gives the lock up. Locks handed out to nested steps are no-ops.
+func (l *stateLock) release() {
+	if l.path == "" || heldStateLock != l {
+		return
+	}
+	os.Remove(l.path)
+	heldStateLock = nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStateLock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	lock, err := acquireStateLock(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nested steps of the same command share the lock
+	nested, err := acquireStateLock(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested.release()
+	if _, err := os.Stat(stateLockPath()); err != nil {
+		t.Fatalf("nested release dropped the lock: %v", err)
+	}
+	lock.release()
+	if _, err := os.Stat(stateLockPath()); !os.IsNotExist(err) {
+		t.Fatalf("lock file left behind: %v", err)
+	}
+
+	// held by another running process
+	if err := os.WriteFile(stateLockPath(), []byte(strconv.Itoa(os.Getppid())+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireStateLock(200 * time.Millisecond); err == nil {
+		t.Fatal("took a lock held by a running process")
+	}
+
+	// left by a process that is gone
+	if err := os.WriteFile(stateLockPath(), []byte("999999999\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = acquireStateLock(0)
+	if err != nil {
+		t.Fatalf("stale lock was not taken over: %v", err)
+	}
+	lock.release()
+}
+
+func TestWatcherSkipsReplacedApply(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := ensureDirs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCurrentProfile("work"); err != nil {
+		t.Fatal(err)
+	}
+	seen := &appliedState{Profile: "work", Time: time.Now().Add(-time.Minute), Files: map[string]appliedFile{}}
+	if err := seen.save(); err != nil {
+		t.Fatal(err)
+	}
+	w := &watcher{st: seen}
+	ran := false
+	if err := w.locked(func() error { ran = true; return nil }); err != nil || !ran {
+		t.Fatalf("action on the current apply: ran %v, err %v", ran, err)
+	}
+
+	// an apply happened since the watcher loaded its state
+	newer := &appliedState{Profile: "work", Time: time.Now(), Files: map[string]appliedFile{}}
+	if err := newer.save(); err != nil {
+		t.Fatal(err)
+	}
+	ran = false
+	if err := w.locked(func() error { ran = true; return nil }); !errors.Is(err, errWatchStale) || ran {
+		t.Fatalf("action on a replaced apply: ran %v, err %v", ran, err)
+	}
+	if _, err := os.Stat(stateLockPath()); !os.IsNotExist(err) {
+		t.Fatalf("lock file left behind: %v", err)
+	}
+}
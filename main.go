@@ -7,11 +7,13 @@
         "os"
         "path/filepath"
         "runtime"
+        "sort"
         "strings"
         "time"
 
         "github.com/Delta456/box-cli-maker/v2"
         "github.com/fatih/color"
+        "github.com/mattn/go-runewidth"
         "github.com/schollz/progressbar/v3"
         "github.com/urfave/cli/v2"
     )
@@ -32,38 +34,285 @@
                     Name:   "list",
                     Usage:  "List available profiles",
                     Action: cmdList,
+                    Flags: []cli.Flag{
+                        &cli.StringSliceFlag{
+                            Name:  "tag",
+                            Usage: "Only list profiles with this tag (repeatable, all must match)",
+                        },
+                        &cli.StringFlag{
+                            Name:  "stale-after",
+                            Value: defaultStaleAfter,
+                            Usage: "Mark profiles not applied for this long as stale",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "json",
+                            Usage: "Print the profiles and their metadata as JSON",
+                        },
+                    },
                 },
                 {
                     Name:   "current",
                     Usage:  "Show the currently active profile",
                     Action: cmdCurrent,
                 },
+                {
+                    Name:   "detect",
+                    Usage:  "Find the profile or backup the config files match, and optionally record it as current",
+                    Action: cmdDetect,
+                    Flags: []cli.Flag{
+                        &cli.BoolFlag{
+                            Name:  "fix",
+                            Usage: "Record the best-matching profile as the current profile",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "no-backups",
+                            Usage: "Only compare with profiles",
+                        },
+                        &cli.IntFlag{
+                            Name:  "limit",
+                            Value: 5,
+                            Usage: "Number of candidates to show (0 for all)",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "json",
+                            Usage: "Print the candidates as JSON",
+                        },
+                    },
+                },
+                {
+                    Name:      "validate",
+                    Usage:     "Check the syntax of a profile's files (all profiles without a name)",
+                    ArgsUsage: "[profile...]",
+                    Action:    cmdValidate,
+                },
+                {
+                    Name:   "doctor",
+                    Usage:  "Check the state directory, profiles, keys, tools and bindings for problems",
+                    Action: cmdDoctor,
+                    Flags: []cli.Flag{
+                        &cli.BoolFlag{
+                            Name:  "fix",
+                            Usage: "Apply the safe repairs: permissions, missing directories, dangling symlinks, repeated bindings and a deleted current profile",
+                        },
+                    },
+                },
+                {
+                    Name:   "status",
+                    Usage:  "Show whether the active profile's files were changed since it was applied",
+                    Action: cmdStatus,
+                    Flags: []cli.Flag{
+                        &cli.BoolFlag{
+                            Name:  "exit-code",
+                            Usage: "Exit with status 1 when files were modified or deleted",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "all",
+                            Usage: "Also list config files that were never managed",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "json",
+                            Usage: "Print the per-file state as JSON",
+                        },
+                    },
+                },
                 {
                     Name:   "apply",
                     Usage:  "Apply a profile (backup current files, then swap)",
-                    Action: cmdApply,
+                    Action: logged(cmdApply),
                     Flags: []cli.Flag{
                         &cli.StringFlag{
                             Name:  "only",
-                            Usage: "Apply only specific config files (comma-separated): gitconfig,zshrc,settings.json",
+                            Usage: "Apply only specific config files (comma-separated): gitconfig,zshrc,settings.json,ssh_hosts",
+                        },
+                        &cli.StringSliceFlag{
+                            Name:  "with",
+                            Usage: "Apply further profiles together with this one (same as work+other)",
+                        },
+                        &cli.StringSliceFlag{
+                            Name:  "var",
+                            Usage: "Set a template variable for this machine (name=value, repeatable)",
+                        },
+                        &cli.StringSliceFlag{
+                            Name:  "prefer",
+                            Usage: "Profile that wins when composed profiles provide the same file (repeatable, first wins)",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "local",
+                            Usage: "Apply the project settings of the repository's devswitch.yaml",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "ssh-agent",
+                            Usage: "Swap the previous profile's keys in ssh-agent for this profile's keys",
+                        },
+                        &cli.DurationFlag{
+                            Name:  "ssh-agent-lifetime",
+                            Usage: "Lifetime of keys added to ssh-agent (e.g. 8h), overrides the profile setting",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "ours",
+                            Usage: "Keep local edits where they conflict with changes to the profile",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "theirs",
+                            Usage: "Take the profile's changes where they conflict with local edits",
+                        },
+                        &cli.StringFlag{
+                            Name:  "conflict",
+                            Value: conflictAbort,
+                            Usage: "What to do with conflicting edits: abort, or markers to write both versions",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "overwrite",
+                            Usage: "Replace locally edited files with the profile without merging",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "force",
+                            Usage: "Apply even if profile files fail validation",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "no-hooks",
+                            Usage: "Do not run the profile's pre-apply and post-apply hooks",
+                        },
+                        &cli.DurationFlag{
+                            Name:  "hook-timeout",
+                            Value: defaultHookTimeout,
+                            Usage: "Time limit for each hook without its own timeout",
                         },
                     },
                 },
                 {
                     Name:   "create",
                     Usage:  "Create a new profile from current configs",
-                    Action: cmdCreate,
+                    Action: logged(cmdCreate),
                     Flags: []cli.Flag{
                         &cli.StringFlag{
                             Name:  "template",
-                            Usage: "Create profile from template: corporate, personal, minimal",
+                            Usage: "Create profile from template (see `devswitch template list`)",
+                        },
+                        &cli.BoolFlag{
+                            Name:    "interactive",
+                            Aliases: []string{"i"},
+                            Usage:   "Ask for identity, template, files to capture and SSH key",
+                        },
+                        &cli.StringFlag{
+                            Name:  "git-name",
+                            Usage: "git user.name for the profile",
+                        },
+                        &cli.StringFlag{
+                            Name:  "email",
+                            Usage: "git user.email for the profile",
+                        },
+                        &cli.StringFlag{
+                            Name:  "editor",
+                            Usage: "git core.editor for the profile",
+                        },
+                        &cli.StringFlag{
+                            Name:  "capture",
+                            Usage: "Current config files to copy: all, none or a list (e.g., gitconfig,zshrc)",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "ssh-key",
+                            Usage: "Generate a new ed25519 SSH key for the profile",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "dry-run",
+                            Usage: "Show the profile that would be created without writing it",
+                        },
+                        &cli.StringFlag{
+                            Name:  "description",
+                            Usage: "Short description of the profile",
+                        },
+                        &cli.StringSliceFlag{
+                            Name:  "tag",
+                            Usage: "Tag the profile (repeatable)",
+                        },
+                        &cli.StringFlag{
+                            Name:  "owner",
+                            Usage: "Who maintains the profile",
+                        },
+                    },
+                },
+                {
+                    Name:  "profile",
+                    Usage: "Rename, copy, delete or edit profiles",
+                    Subcommands: []*cli.Command{
+                        {
+                            Name:      "rename",
+                            Usage:     "Rename a profile, updating the current profile, bindings and profiles extending it",
+                            ArgsUsage: "<profile> <new-name>",
+                            Action:    logged(cmdProfileRename),
+                        },
+                        {
+                            Name:      "copy",
+                            Usage:     "Copy a profile under a new name",
+                            ArgsUsage: "<profile> <new-name>",
+                            Action:    logged(cmdProfileCopy),
+                        },
+                        {
+                            Name:      "delete",
+                            Usage:     "Move a profile to the trash (~/.devswitch/trash)",
+                            ArgsUsage: "<profile>",
+                            Action:    logged(cmdProfileDelete),
+                            Flags: []cli.Flag{
+                                &cli.BoolFlag{
+                                    Name:  "force",
+                                    Usage: "Delete even if the profile is active or bound to directories",
+                                },
+                            },
+                        },
+                        {
+                            Name:      "edit",
+                            Usage:     "Edit a profile file in $EDITOR and check it before saving (default: devswitch.yaml)",
+                            ArgsUsage: "<profile> [file]",
+                            Action:    logged(cmdProfileEdit),
+                        },
+                    },
+                },
+                {
+                    Name:  "template",
+                    Usage: "Manage profile templates",
+                    Subcommands: []*cli.Command{
+                        {
+                            Name:   "list",
+                            Usage:  "List built-in and user templates",
+                            Action: cmdTemplateList,
+                        },
+                        {
+                            Name:   "show",
+                            Usage:  "Show the files of a template",
+                            Action: cmdTemplateShow,
+                            ArgsUsage: "<template>",
+                            Flags: []cli.Flag{
+                                &cli.BoolFlag{
+                                    Name:  "content",
+                                    Usage: "Also print the content of each file",
+                                },
+                            },
+                        },
+                        {
+                            Name:   "create-from",
+                            Usage:  "Save a profile as a user template (private keys are left out)",
+                            Action: cmdTemplateCreateFrom,
+                            ArgsUsage: "<profile> [template]",
+                            Flags: []cli.Flag{
+                                &cli.BoolFlag{
+                                    Name:  "force",
+                                    Usage: "Replace an existing user template",
+                                },
+                            },
+                        },
+                        {
+                            Name:   "delete",
+                            Usage:  "Delete a user template",
+                            Action: cmdTemplateDelete,
+                            ArgsUsage: "<template>",
                         },
                     },
                 },
                 {
                     Name:   "backup",
                     Usage:  "Backup current configs without switching",
-                    Action: cmdBackup,
+                    Action: logged(cmdBackup),
                 },
                 {
                     Name:   "diff",
@@ -74,8 +323,221 @@
                 {
                     Name:   "rollback",
                     Usage:  "Rollback to a previous backup",
-                    Action: cmdRollback,
+                    Action: logged(cmdRollback),
                     ArgsUsage: "[backup-timestamp]",
+                    Flags: []cli.Flag{
+                        &cli.BoolFlag{
+                            Name:  "local",
+                            Usage: "Rollback project settings written by 'apply --local'",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "no-hooks",
+                            Usage: "Do not run the profile's pre-rollback and post-rollback hooks",
+                        },
+                        &cli.DurationFlag{
+                            Name:  "hook-timeout",
+                            Value: defaultHookTimeout,
+                            Usage: "Time limit for each hook without its own timeout",
+                        },
+                    },
+                },
+                {
+                    Name:   "history",
+                    Usage:  "Show logged operations: create, apply, backup, rollback, save, undo and redo",
+                    Action: cmdHistory,
+                    Flags: []cli.Flag{
+                        &cli.StringFlag{
+                            Name:  "profile",
+                            Usage: "Only operations that switched from or to this profile",
+                        },
+                        &cli.StringFlag{
+                            Name:  "command",
+                            Usage: "Only this command: create, apply, backup, rollback, save, undo or redo",
+                        },
+                        &cli.StringFlag{
+                            Name:  "since",
+                            Usage: "Only operations after a date (2024-01-31) or within a duration (7d, 12h)",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "failed",
+                            Usage: "Only failed or cancelled operations",
+                        },
+                        &cli.IntFlag{
+                            Name:  "limit",
+                            Value: 20,
+                            Usage: "Show at most this many of the latest operations (0 for all)",
+                        },
+                        &cli.BoolFlag{
+                            Name:    "verbose",
+                            Aliases: []string{"v"},
+                            Usage:   "Show files with hashes, hook output and errors",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "json",
+                            Usage: "Print the matching events as JSON lines",
+                        },
+                    },
+                },
+                {
+                    Name:   "save",
+                    Usage:  "Copy local edits of managed files back into the active profile",
+                    Action: logged(cmdSave),
+                    ArgsUsage: "[profile]",
+                    Flags: []cli.Flag{
+                        &cli.StringFlag{
+                            Name:  "only",
+                            Usage: "Save only these files (e.g., gitconfig,zshrc), also ones the profile does not have yet",
+                        },
+                        &cli.BoolFlag{
+                            Name:    "yes",
+                            Aliases: []string{"y"},
+                            Usage:   "Save without asking after showing the diff",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "force",
+                            Usage: "Save even if the profile changed since it was applied",
+                        },
+                    },
+                },
+                {
+                    Name:   "watch",
+                    Usage:  "Watch the applied files and record, save or restore local edits",
+                    Action: cmdWatch,
+                    Flags: []cli.Flag{
+                        &cli.StringFlag{
+                            Name:  "policy",
+                            Value: watchRecord,
+                            Usage: "Policy for files the profile's watch settings do not name: record, save, restore or ignore",
+                        },
+                        &cli.DurationFlag{
+                            Name:  "interval",
+                            Value: 2 * time.Second,
+                            Usage: "How often to check the files when polling",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "poll",
+                            Usage: "Poll instead of using file notifications",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "status",
+                            Usage: "Show the state of the running watcher and exit",
+                        },
+                    },
+                },
+                {
+                    Name:   "undo",
+                    Usage:  "Undo the last apply, restoring the configs exactly as they were before it",
+                    Action: cmdUndo,
+                    Flags:  undoFlags,
+                },
+                {
+                    Name:   "redo",
+                    Usage:  "Redo the last undone apply",
+                    Action: cmdRedo,
+                    Flags:  undoFlags,
+                },
+                {
+                    Name:   "show",
+                    Usage:  "Show a profile's details, layers and files",
+                    Action: cmdShow,
+                    ArgsUsage: "<profile>",
+                    Flags: []cli.Flag{
+                        &cli.BoolFlag{
+                            Name:  "resolved",
+                            Usage: "Show the effective files after inheritance, with the layer each comes from",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "content",
+                            Usage: "With --resolved, also print the effective content of each file",
+                        },
+                    },
+                },
+                {
+                    Name:   "exec",
+                    Usage:  "Run a command with a profile active for that process only",
+                    Action: cmdExec,
+                    ArgsUsage: "<profile> -- <command> [args...]",
+                    SkipFlagParsing: true,
+                },
+                {
+                    Name:   "shell",
+                    Usage:  "Start a subshell with a profile active, leaving global files untouched",
+                    Action: cmdShell,
+                    ArgsUsage: "<profile>",
+                },
+                {
+                    Name:   "init",
+                    Usage:  "Print shell integration code: eval \"$(devswitch init zsh)\"",
+                    Action: cmdInit,
+                    ArgsUsage: "<bash|zsh|fish>",
+                    Flags: []cli.Flag{
+                        &cli.StringFlag{
+                            Name:  "on-change",
+                            Value: "reload",
+                            Usage: "What other open shells do after a switch: reload or notify",
+                        },
+                        &cli.BoolFlag{
+                            Name:  "dir-switch",
+                            Value: true,
+                            Usage: "Activate profiles bound to directories when you cd into them",
+                        },
+                    },
+                },
+                {
+                    Name:   "hook-dir",
+                    Usage:  "Print code that activates the profile bound to the working directory",
+                    Action: cmdHookDir,
+                    ArgsUsage: "<bash|zsh|fish>",
+                    Hidden: true,
+                },
+                {
+                    Name:   "bind",
+                    Usage:  "Bind a directory tree to a profile for automatic switching",
+                    Action: cmdBind,
+                    ArgsUsage: "<profile> [dir]",
+                },
+                {
+                    Name:   "unbind",
+                    Usage:  "Remove the profile binding of a directory",
+                    Action: cmdUnbind,
+                    ArgsUsage: "[dir]",
+                },
+                {
+                    Name:   "bindings",
+                    Usage:  "List directory bindings",
+                    Action: cmdBindings,
+                },
+                {
+                    Name:   "allow",
+                    Usage:  "Trust a repository-local .devswitch.yaml in its current form",
+                    Action: cmdAllow,
+                    ArgsUsage: "[path]",
+                    Flags: []cli.Flag{
+                        &cli.BoolFlag{
+                            Name:    "yes",
+                            Aliases: []string{"y"},
+                            Usage:   "Allow without asking after showing the file",
+                        },
+                    },
+                },
+                {
+                    Name:   "deny",
+                    Usage:  "Never use a repository-local .devswitch.yaml",
+                    Action: cmdDeny,
+                    ArgsUsage: "[path]",
+                },
+                {
+                    Name:   "hook-env",
+                    Usage:  "Print code that syncs the calling shell with the active profile",
+                    Action: cmdHookEnv,
+                    ArgsUsage: "<bash|zsh|fish>",
+                    Hidden: true,
+                    Flags: []cli.Flag{
+                        &cli.BoolFlag{
+                            Name:  "init",
+                            Usage: "Record the current rc file instead of sourcing it",
+                        },
+                    },
                 },
             },
         }
@@ -152,13 +614,8 @@
             },
         })
 
-        // SSH Config
-        cfgs = append(cfgs, configFile{
-            Name: "ssh_config",
-            Src: func() string {
-                return filepath.Join(homeDir(), ".ssh", "config")
-            },
-        })
+        // ~/.ssh/config is not swapped: profiles declare host aliases,
+        // rendered into an included file by syncSSHHosts
 
         // SSH Keys (id_rsa, id_ed25519)
         sshKeys := []string{"id_rsa", "id_rsa.pub", "id_ed25519", "id_ed25519.pub"}
@@ -279,7 +736,8 @@
         if err != nil {
             return "", err
         }
-        return string(b), nil
+        // tolerate a trailing newline from editing the file by hand
+        return strings.TrimSpace(string(b)), nil
     }
 
     func boxInfo(title, body string) {
@@ -291,67 +749,108 @@
             Color: "Cyan",   // Box color
             TitlePos: "Top", // Title position
         })
+        // the box panics unless the body is as wide as the title plus its
+        // two spaces, less the padding on both sides
+        first, rest, multiline := strings.Cut(body, "\n")
+        if pad := runewidth.StringWidth(title) + 2 - 2*4 - runewidth.StringWidth(first); pad > 0 {
+            body = first + strings.Repeat(" ", pad)
+            if multiline {
+                body += "\n" + rest
+            }
+        }
         Box.Println(title, body)
     }
 
     // ---------- Commands ----------
 
-    func cmdList(c *cli.Context) error {
+    func cmdCurrent(c *cli.Context) error {
         if err := ensureDirs(); err != nil {
             return err
         }
-        entries, err := os.ReadDir(profilesDir())
+        prof, err := readCurrentProfile()
         if err != nil {
-            return err
-        }
-        if len(entries) == 0 {
-            boxInfo("No Profiles Found", "Create your first profile with 'devswitch create <name>'")
+            boxInfo("No Active Profile", "Use 'devswitch apply <name>' to activate a profile")
             return nil
         }
-        
-        profileList := "\n"
-        for _, e := range entries {
-            if e.IsDir() {
-                profileList += fmt.Sprintf("  • %s\n", e.Name())
-            }
+        origins := readOrigins()
+        if origins == nil || origins.name() != prof {
+            boxInfo("Current Profile", prof)
+            return nil
         }
-        boxInfo("Available Profiles", profileList)
-        return nil
-    }
 
-    func cmdCurrent(c *cli.Context) error {
-        if err := ensureDirs(); err != nil {
-            return err
+        // show which profile each file came from
+        info := prof + "\n\n"
+        files := make([]string, 0, len(origins.Files))
+        for f := range origins.Files {
+            files = append(files, f)
         }
-        prof, err := readCurrentProfile()
-        if err != nil {
-            boxInfo("No Active Profile", "Use 'devswitch apply <name>' to activate a profile")
-            return nil
+        sort.Strings(files)
+        for _, f := range files {
+            info += fmt.Sprintf("  • %-20s ← %s\n", f, origins.Files[f])
         }
-        boxInfo("Current Profile", prof)
+        boxInfo("Current Profile", info)
         return nil
     }
 
     func cmdApply(c *cli.Context) error {
+        if c.Bool("local") {
+            return cmdApplyLocal(c)
+        }
         if c.Args().Len() == 0 {
             return fmt.Errorf("profile name required")
         }
-        profile := c.Args().First()
-        profPath := filepath.Join(profilesDir(), profile)
-        if _, err := os.Stat(profPath); err != nil {
-            return fmt.Errorf("profile %s does not exist", profile)
+        // several profiles can be applied together: work+kube-prod or --with
+        members := splitProfileSpec(c.Args().First())
+        for _, w := range c.StringSlice("with") {
+            members = append(members, splitProfileSpec(w)...)
+        }
+        profile := strings.Join(members, profileSeparator)
+        if err := profileExists(profile); err != nil {
+            return err
         }
 
         if err := ensureDirs(); err != nil {
             return err
         }
+        lock, err := acquireStateLock(stateLockWait)
+        if err != nil {
+            return err
+        }
+        defer lock.release()
 
-        // backup current configs
-        fmt.Printf("%s Creating backup...\n", color.YellowString("⚠️ "))
-        if err := cmdBackup(c); err != nil {
-            color.Red("❌ Backup failed: %v", err)
+        for _, m := range members {
+            if err := promptTemplateVars(m, c.StringSlice("var")); err != nil {
+                return err
+            }
+        }
+
+        comp, err := composeProfiles(members, c.StringSlice("prefer"))
+        if err != nil {
+            return err
+        }
+        // profiles that extend others are assembled from their layers first
+        var srcDir string
+        if len(members) > 1 {
+            srcDir, err = materializeComposition(comp)
         } else {
-            color.Green("✅ Backup created successfully")
+            srcDir, err = profileSourceDir(profile)
+        }
+        if err != nil {
+            return err
+        }
+        manifest, err := loadResolvedManifest(profile)
+        if err != nil {
+            return err
+        }
+        prevProfile, _ := readCurrentProfile()
+        noteTransition(prevProfile, profile)
+
+        agentLifetime := c.Duration("ssh-agent-lifetime")
+        if !c.IsSet("ssh-agent-lifetime") && manifest.SSH.Agent.Lifetime != "" {
+            agentLifetime, err = time.ParseDuration(manifest.SSH.Agent.Lifetime)
+            if err != nil {
+                return fmt.Errorf("invalid ssh agent lifetime %q: %v", manifest.SSH.Agent.Lifetime, err)
+            }
         }
 
         configs := detectConfigFiles()
@@ -379,6 +878,57 @@
             color.Blue("🎯 Selective apply: only %s", onlyFlag)
         }
 
+        if !c.Bool("force") {
+            if err := validateBeforeApply(profile, srcDir, allowedFiles); err != nil {
+                return err
+            }
+        }
+
+        // targets edited since the last apply are merged rather than
+        // overwritten; conflicts abort before anything is touched
+        prevApplied, err := loadAppliedState()
+        if err != nil {
+            return err
+        }
+        var merges map[string]*mergePlan
+        if !c.Bool("overwrite") {
+            policy, err := conflictPolicy(c)
+            if err != nil {
+                return err
+            }
+            merges, err = planMerges(prevApplied, srcDir, profile, allowedFiles, policy)
+            if err != nil {
+                return err
+            }
+        }
+
+        // pre-apply hooks can still cancel, nothing has been touched yet
+        hc := &hookContext{
+            Event:        hookPreApply,
+            OldProfile:   prevProfile,
+            NewProfile:   profile,
+            ChangedFiles: changedTargets(srcDir, allowedFiles),
+        }
+        if !c.Bool("no-hooks") {
+            if _, err := runHooks(profile, hc, c.Duration("hook-timeout")); err != nil {
+                return fmt.Errorf("apply cancelled: %w", err)
+            }
+        }
+
+        // backup current configs
+        fmt.Printf("%s Creating backup...\n", color.YellowString("⚠️ "))
+        if err := cmdBackup(c); err != nil {
+            color.Red("❌ Backup failed: %v", err)
+        } else {
+            color.Green("✅ Backup created successfully")
+        }
+
+        undoTmp, err := beginUndo()
+        if err != nil {
+            return fmt.Errorf("failed to snapshot current configs: %v", err)
+        }
+        applied := newAppliedState(profile, allowedFiles != nil)
+
         for _, cfg := range configs {
             // Skip if --only flag is used and this file is not included
             if allowedFiles != nil && !allowedFiles[cfg.Name] {
@@ -386,33 +936,89 @@
                 continue
             }
 
-            src := filepath.Join(profPath, cfg.Name)
+            src := filepath.Join(srcDir, cfg.Name)
             if _, err := os.Stat(src); err != nil {
                 color.Yellow("⚠️  Skipping %s (not found in profile)", cfg.Name)
                 continue
             }
+            if m, ok := merges[cfg.Name]; ok {
+                if err := applyMerge(m, cfg, applied); err != nil {
+                    return err
+                }
+                continue
+            }
             color.Blue("📋 Applying %s...", cfg.Name)
+            before, _ := contentHash(cfg.Src())
             if err := copyFile(src, cfg.Src()); err != nil {
                 return err
             }
+            noteFileChange(cfg.Name, cfg.Src(), before)
+            if err := applied.record(cfg.Name, cfg.Src()); err != nil {
+                return err
+            }
+        }
+
+        // SSH host aliases are generated rather than copied, so every
+        // profile's identities stay usable and only the default changes
+        if allowedFiles == nil || allowedFiles["ssh_hosts"] {
+            if err := secureSSHIdentities(profile); err != nil {
+                return fmt.Errorf("failed to update ssh hosts: %v", err)
+            }
+            count, err := syncSSHHosts(profile)
+            if err != nil {
+                return fmt.Errorf("failed to update ssh hosts: %v", err)
+            }
+            if count > 0 {
+                color.Blue("🔑 Rendered %d ssh host aliases into %s", count, sshHostsFile())
+            }
+        }
+
+        if c.Bool("ssh-agent") || manifest.SSH.Agent.Enabled {
+            applySSHAgent(prevProfile, profile, agentLifetime)
         }
 
         if err := writeCurrentProfile(profile); err != nil {
             return err
         }
+        if err := writeOrigins(comp); err != nil {
+            return err
+        }
+        if err := markApplied(profile); err != nil {
+            color.Yellow("⚠️  Could not record apply time: %v", err)
+        }
+        if err := applied.save(); err != nil {
+            return err
+        }
+        if err := recordUndo(undoTmp, prevProfile, profile); err != nil {
+            color.Yellow("⚠️  Could not record undo step: %v", err)
+        }
+        if err := bumpGeneration(); err != nil {
+            color.Yellow("⚠️  Could not notify open shells: %v", err)
+        }
 
-        boxInfo("Profile Applied", fmt.Sprintf("%s\n\n%s", profile, "✅ Done! Please restart your terminal or reload your shell."))
+        if !c.Bool("no-hooks") {
+            hc.Event = hookPostApply
+            if _, err := runHooks(profile, hc, c.Duration("hook-timeout")); err != nil {
+                color.Yellow("⚠️  %v", err)
+            }
+        }
+
+        done := "✅ Done! Please restart your terminal or reload your shell."
+        if shellIntegrationActive() {
+            done = "✅ Done! Your shell has been updated."
+        }
+        boxInfo("Profile Applied", fmt.Sprintf("%s\n\n%s", profile, done))
         return nil
     }
 
     func cmdCreate(c *cli.Context) error {
-        if c.Args().Len() == 0 {
-            return fmt.Errorf("profile name required")
+        if wizardRequested(c) {
+            return cmdCreateWizard(c)
         }
         profile := c.Args().First()
-        profPath := filepath.Join(profilesDir(), profile)
-        if _, err := os.Stat(profPath); err == nil {
-            return fmt.Errorf("profile %s already exists", profile)
+        profPath, err := newProfilePath(profile)
+        if err != nil {
+            return err
         }
         if err := os.MkdirAll(profPath, 0o755); err != nil {
             return err
@@ -425,7 +1031,6 @@
             if err := createFromTemplate(profPath, template); err != nil {
                 return fmt.Errorf("failed to create from template: %v", err)
             }
-            boxInfo("Profile Created", fmt.Sprintf("%s (from %s template)", profile, template))
         } else {
             // Create profile from current configs
             configs := detectConfigFiles()
@@ -437,121 +1042,27 @@
                     }
                 }
             }
+        }
+        // recorded before anything is printed, so output problems cannot
+        // lose the metadata or history of a profile that already exists
+        if err := initProfileMeta(c, profPath); err != nil {
+            return err
+        }
+        noteProfileFiles(profile)
+        if template != "" {
+            boxInfo("Profile Created", fmt.Sprintf("%s (from %s template)", profile, template))
+        } else {
             boxInfo("Profile Created", profile)
         }
         return nil
     }
 
     func createFromTemplate(profPath, template string) error {
-        templates := map[string]map[string]string{
-            "corporate": {
-                ".gitconfig": `[user]
-    name = Corporate User
-    email = user@company.com
-[init]
-    defaultBranch = main
-[pull]
-    rebase = false
-[core]
-    autocrlf = input
-    editor = code --wait`,
-                ".zshrc": `# Corporate shell configuration
-export PATH="/usr/local/bin:$PATH"
-export EDITOR="code"
-
-# Company aliases
-alias deploy="kubectl apply -f"
-alias logs="kubectl logs -f"
-alias status="git status"
-
-# Load company-specific configurations
-[ -f ~/.company_profile ] && source ~/.company_profile`,
-                "settings.json": `{
-    "editor.formatOnSave": true,
-    "editor.codeActionsOnSave": {
-        "source.organizeImports": true
-    },
-    "git.confirmSync": false,
-    "workbench.colorTheme": "Visual Studio Dark",
-    "terminal.integrated.shell.osx": "/bin/zsh"
-}`,
-                ".npmrc": `registry=https://registry.company.com/
-//registry.company.com/:_authToken=your-company-token
-save-exact=true`,
-                ".env": `NODE_ENV=production
-API_URL=https://api.company.com
-LOG_LEVEL=info`,
-            },
-            "personal": {
-                ".gitconfig": `[user]
-    name = Your Name
-    email = your.personal@email.com
-[init]
-    defaultBranch = main
-[pull]
-    rebase = true
-[core]
-    editor = vim`,
-                ".zshrc": `# Personal shell configuration
-export PATH="$HOME/bin:/usr/local/bin:$PATH"
-export EDITOR="vim"
-
-# Personal aliases
-alias ll="ls -la"
-alias ..="cd .."
-alias ...="cd ../.."
-alias gs="git status"
-alias gp="git pull"
-
-# Oh My Zsh configuration
-export ZSH="$HOME/.oh-my-zsh"
-ZSH_THEME="agnoster"
-plugins=(git docker kubectl)
-source $ZSH/oh-my-zsh.sh`,
-                "settings.json": `{
-    "editor.fontSize": 14,
-    "editor.tabSize": 2,
-    "workbench.colorTheme": "One Dark Pro",
-    "terminal.integrated.fontSize": 12,
-    "git.autofetch": true
-}`,
-                ".npmrc": `registry=https://registry.npmjs.org/
-save-exact=false
-fund=false`,
-                ".env": `NODE_ENV=development
-DEBUG=true`,
-            },
-            "minimal": {
-                ".gitconfig": `[user]
-    name = User
-    email = user@example.com
-[init]
-    defaultBranch = main`,
-                ".zshrc": `# Minimal shell configuration
-export PATH="/usr/local/bin:$PATH"
-alias ls="ls -G"
-alias ll="ls -la"`,
-                "settings.json": `{
-    "editor.formatOnSave": true,
-    "workbench.colorTheme": "Default Dark+"
-}`,
-            },
-        }
-
-        templateData, exists := templates[template]
-        if !exists {
-            return fmt.Errorf("template '%s' not found. Available templates: corporate, personal, minimal", template)
-        }
-
-        for filename, content := range templateData {
-            filepath := filepath.Join(profPath, filename)
-            if err := os.WriteFile(filepath, []byte(content), 0o644); err != nil {
-                return fmt.Errorf("failed to write %s: %v", filename, err)
-            }
-            color.Green("✅ Created %s", filename)
+        t, err := findTemplate(template)
+        if err != nil {
+            return err
         }
-
-        return nil
+        return writeTemplate(profPath, t)
     }
 
     func cmdBackup(c *cli.Context) error {
@@ -574,6 +1085,7 @@ alias ll="ls -la"`,
             }
         }
 
+        noteBackup(backupDir)
         boxInfo("Backup Complete", backupDir)
         return nil
     }
@@ -703,9 +1215,17 @@ alias ll="ls -la"`,
     }
 
     func cmdRollback(c *cli.Context) error {
+        if c.Bool("local") {
+            return cmdRollbackLocal(c)
+        }
         if err := ensureDirs(); err != nil {
             return err
         }
+        lock, err := acquireStateLock(stateLockWait)
+        if err != nil {
+            return err
+        }
+        defer lock.release()
 
         backupPath := ""
         if c.Args().Len() > 0 {
@@ -744,6 +1264,22 @@ alias ll="ls -la"`,
             color.Blue("🔄 Using latest backup: %s", latestBackup)
         }
 
+        // the hooks of the profile being rolled back from get a say
+        prevProfile, _ := readCurrentProfile()
+        noteTransition(prevProfile, "")
+        noteBackup(backupPath)
+        hc := &hookContext{
+            Event:        hookPreRollback,
+            OldProfile:   prevProfile,
+            ChangedFiles: changedTargets(backupPath, nil),
+            Backup:       filepath.Base(backupPath),
+        }
+        if !c.Bool("no-hooks") {
+            if _, err := runHooks(prevProfile, hc, c.Duration("hook-timeout")); err != nil {
+                return fmt.Errorf("rollback cancelled: %w", err)
+            }
+        }
+
         // Restore files from backup
         configs := detectConfigFiles()
         restoredCount := 0
@@ -756,9 +1292,11 @@ alias ll="ls -la"`,
             }
             
             color.Blue("📋 Restoring %s...", cfg.Name)
+            before, _ := contentHash(cfg.Src())
             if err := copyFile(backupFile, cfg.Src()); err != nil {
                 return fmt.Errorf("failed to restore %s: %v", cfg.Name, err)
             }
+            noteFileChange(cfg.Name, cfg.Src(), before)
             restoredCount++
         }
 
@@ -767,8 +1305,28 @@ alias ll="ls -la"`,
         if err := os.Remove(profileFile); err != nil && !os.IsNotExist(err) {
             color.Yellow("⚠️  Could not clear current profile: %v", err)
         }
+        if err := clearOrigins(); err != nil {
+            color.Yellow("⚠️  Could not clear the composed profile's file origins: %v", err)
+        }
+        if err := clearAppliedState(); err != nil {
+            color.Yellow("⚠️  Could not clear apply record: %v", err)
+        }
+        if err := bumpGeneration(); err != nil {
+            color.Yellow("⚠️  Could not notify open shells: %v", err)
+        }
 
-        successMsg := fmt.Sprintf("Restored %d config files from backup\n\n✅ Rollback complete! Please restart your terminal.", restoredCount)
+        if !c.Bool("no-hooks") {
+            hc.Event = hookPostRollback
+            if _, err := runHooks(prevProfile, hc, c.Duration("hook-timeout")); err != nil {
+                color.Yellow("⚠️  %v", err)
+            }
+        }
+
+        done := "✅ Rollback complete! Please restart your terminal."
+        if shellIntegrationActive() {
+            done = "✅ Rollback complete! Your shell has been updated."
+        }
+        successMsg := fmt.Sprintf("Restored %d config files from backup\n\n%s", restoredCount, done)
         boxInfo("Rollback Complete", successMsg)
         return nil
     }
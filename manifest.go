@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifestName is the optional per-profile settings file that lives next to
+// the profile's config files.
+const manifestName = "devswitch.yaml"
+
+// profileManifest holds the declarative settings of a profile.
+type profileManifest struct {
+	Description string                `yaml:"description,omitempty"`
+	Tags        []string              `yaml:"tags,omitempty"`
+	Owner       string                `yaml:"owner,omitempty"`
+	Extends     []string              `yaml:"extends,omitempty"` // profiles layered below this one
+	Merge       map[string]string     `yaml:"merge,omitempty"`   // file -> replace, append or merge
+	Vars        map[string]string     `yaml:"vars,omitempty"`    // values for *.tmpl files
+	Prompts     []templatePrompt      `yaml:"prompts,omitempty"` // vars asked for once per machine
+	SSH         sshManifest           `yaml:"ssh,omitempty"`
+	Hooks       map[string][]hookSpec `yaml:"hooks,omitempty"` // event -> commands
+	Watch       map[string]string     `yaml:"watch,omitempty"` // file or "*" -> watch policy
+}
+
+// loadManifest reads the manifest of the profile at profPath. A profile
+// without a manifest yields an empty one.
+func loadManifest(profPath string) (*profileManifest, error) {
+	m := &profileManifest{}
+	data, err := os.ReadFile(filepath.Join(profPath, manifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %v", manifestName, filepath.Base(profPath), err)
+	}
+	return m, nil
+}
+
+// profileNames returns the names of all profiles, sorted.
+func profileNames() ([]string, error) {
+	entries, err := os.ReadDir(profilesDir())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		// dot directories are in-progress copies, never profiles
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// editManifest changes the manifest of the profile at profPath in place,
+// keeping the keys it does not touch and their comments. A profile without
+// a manifest gets one.
+func editManifest(profPath string, edit func(root *yaml.Node) error) error {
+	path := filepath.Join(profPath, manifestName)
+	var doc yaml.Node
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid %s in %s: %v", manifestName, filepath.Base(profPath), err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid %s in %s: not a mapping", manifestName, filepath.Base(profPath))
+	}
+	if err := edit(root); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0o644)
+}
+
+// setManifestKey sets key in a manifest mapping to value.
+func setManifestKey(root *yaml.Node, key string, value interface{}) error {
+	var v yaml.Node
+	if err := v.Encode(value); err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			root.Content[i+1] = &v
+			return nil
+		}
+	}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &v)
+	return nil
+}
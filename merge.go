@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// How apply resolves hunks that were changed both locally and in the
+// profile.
+const (
+	conflictAbort   = "abort"   // apply nothing and report the files
+	conflictMarkers = "markers" // write both versions between markers
+	conflictOurs    = "ours"    // keep the local version of the hunk
+	conflictTheirs  = "theirs"  // take the profile's version of the hunk
+)
+
+const (
+	markerOurs   = "<<<<<<< local"
+	markerSep    = "======="
+	markerTheirs = ">>>>>>> profile"
+)
+
+// lineMatches maps each line of a to the line of b it is matched with in a
+// minimal diff, or -1.
+func lineMatches(a, b []string) ([]int, bool) {
+	ops, ok := diffLines(a, b)
+	if !ok {
+		return nil, false
+	}
+	m := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range ops {
+		switch op.Kind {
+		case ' ':
+			m[i] = j
+			i++
+			j++
+		case '-':
+			m[i] = -1
+			i++
+		case '+':
+			j++
+		}
+	}
+	return m, true
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// merge3 merges the changes ours and theirs made to base, line by line.
+// Hunks changed differently on both sides are resolved by policy and
+// counted in conflicts. ok is false for binary or oversized input.
+func merge3(base, ours, theirs []byte, policy string) (out []byte, conflicts int, ok bool) {
+	for _, data := range [][]byte{base, ours, theirs} {
+		if bytes.IndexByte(data, 0) >= 0 {
+			return nil, 0, false
+		}
+	}
+	b, o, t := splitLines(base), splitLines(ours), splitLines(theirs)
+	mo, ok1 := lineMatches(b, o)
+	mt, ok2 := lineMatches(b, t)
+	if !ok1 || !ok2 {
+		return nil, 0, false
+	}
+
+	var res []string
+	i, j, k := 0, 0, 0
+	for i < len(b) || j < len(o) || k < len(t) {
+		// next base line kept by both sides
+		next := i
+		for next < len(b) && (mo[next] < 0 || mt[next] < 0) {
+			next++
+		}
+		if next < len(b) && next == i && mo[i] == j && mt[i] == k {
+			res = append(res, b[i])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+		oEnd, tEnd := len(o), len(t)
+		if next < len(b) {
+			oEnd, tEnd = mo[next], mt[next]
+		}
+		bc, oc, tc := b[i:next], o[j:oEnd], t[k:tEnd]
+		switch {
+		case equalLines(oc, bc):
+			res = append(res, tc...)
+		case equalLines(tc, bc), equalLines(oc, tc):
+			res = append(res, oc...)
+		default:
+			conflicts++
+			switch policy {
+			case conflictOurs:
+				res = append(res, oc...)
+			case conflictTheirs:
+				res = append(res, tc...)
+			default:
+				res = append(res, markerOurs)
+				res = append(res, oc...)
+				res = append(res, markerSep)
+				res = append(res, tc...)
+				res = append(res, markerTheirs)
+			}
+		}
+		i, j, k = next, oEnd, tEnd
+	}
+	if len(res) == 0 {
+		return nil, conflicts, true
+	}
+	return []byte(strings.Join(res, "\n") + "\n"), conflicts, true
+}
+
+// mergePlan is what apply does with a target that was edited since the
+// profile was last applied.
+type mergePlan struct {
+	Keep      bool   // the profile did not change; leave the local file alone
+	Content   []byte // merged content to write
+	Base      []byte // the profile's new content, the base of the next merge
+	Conflicts int
+}
+
+// conflictPolicy reads the apply flags that control merging.
+func conflictPolicy(c *cli.Context) (string, error) {
+	policy := c.String("conflict")
+	switch policy {
+	case conflictAbort, conflictMarkers:
+	default:
+		return "", fmt.Errorf("--conflict must be abort or markers, got %q", policy)
+	}
+	if c.Bool("ours") && c.Bool("theirs") {
+		return "", fmt.Errorf("--ours and --theirs cannot be combined")
+	}
+	if c.Bool("ours") {
+		policy = conflictOurs
+	}
+	if c.Bool("theirs") {
+		policy = conflictTheirs
+	}
+	return policy, nil
+}
+
+// planMerges finds the targets that were edited since the last apply of the
+// same profile and decides how to combine them with the profile, using the
+// content applied last time as the common base. Switching to another
+// profile replaces edited targets, which is only reported.
+func planMerges(prev *appliedState, srcDir, profile string, allowed map[string]bool, policy string) (map[string]*mergePlan, error) {
+	if prev == nil {
+		return nil, nil
+	}
+	plans := map[string]*mergePlan{}
+	var conflicted []string
+	for _, cfg := range detectConfigFiles() {
+		if allowed != nil && !allowed[cfg.Name] {
+			continue
+		}
+		rec, managed := prev.Files[cfg.Name]
+		theirs, err := os.ReadFile(filepath.Join(srcDir, cfg.Name))
+		if err != nil || !managed {
+			continue
+		}
+		ours, err := os.ReadFile(cfg.Src())
+		// compared with the profile's content, so edits merged in by an
+		// earlier apply are merged again rather than dropped
+		if err != nil || hashBytes(ours) == rec.profileHash() {
+			continue
+		}
+		if rec.Conflicted && bytes.Contains(ours, []byte(markerOurs)) {
+			if policy == conflictTheirs {
+				continue
+			}
+			conflicted = append(conflicted, cfg.Name+" (unresolved conflict markers)")
+			continue
+		}
+		if prev.Profile != profile {
+			color.Yellow("⚠️  %s was edited after %s was applied; the edits are replaced and kept in the backup", cfg.Name, prev.Profile)
+			continue
+		}
+		base, err := os.ReadFile(prev.basePath(cfg.Name))
+		if err != nil {
+			color.Yellow("⚠️  %s was edited since the last apply but no base is recorded; replacing it", cfg.Name)
+			continue
+		}
+
+		if bytes.Equal(theirs, base) {
+			plans[cfg.Name] = &mergePlan{Keep: true, Base: theirs}
+			continue
+		}
+		merged, conflicts, ok := merge3(base, ours, theirs, policy)
+		if !ok {
+			// whole-file choice for content that cannot be merged by line
+			switch policy {
+			case conflictOurs:
+				merged = ours
+			case conflictTheirs:
+				merged = theirs
+			default:
+				conflicted = append(conflicted, cfg.Name+" (binary)")
+				continue
+			}
+			conflicts = 1
+		}
+		if conflicts > 0 && policy == conflictAbort {
+			conflicted = append(conflicted, fmt.Sprintf("%s (%d conflicts)", cfg.Name, conflicts))
+			continue
+		}
+		plans[cfg.Name] = &mergePlan{Content: merged, Base: theirs, Conflicts: conflicts}
+	}
+	if len(conflicted) > 0 {
+		sort.Strings(conflicted)
+		return nil, fmt.Errorf("local edits conflict with the profile in %s; nothing was changed. Resolve with --ours, --theirs or --conflict=markers, or 'devswitch save' the edits first", strings.Join(conflicted, ", "))
+	}
+	return plans, nil
+}
+
+// applyMerge carries out a plan for one target and records it.
+func applyMerge(p *mergePlan, cfg configFile, applied *appliedState) error {
+	if p.Keep {
+		color.Blue("📝 Keeping local edits to %s (unchanged in profile)", cfg.Name)
+		return applied.recordMerged(cfg.Name, cfg.Src(), p.Base)
+	}
+	before, _ := contentHash(cfg.Src())
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(cfg.Src()); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := os.WriteFile(cfg.Src(), p.Content, perm); err != nil {
+		return err
+	}
+	noteFileChange(cfg.Name, cfg.Src(), before)
+	switch {
+	case p.Conflicts == 0:
+		color.Blue("🔀 Merged local edits into %s", cfg.Name)
+	case bytes.Contains(p.Content, []byte(markerOurs)):
+		color.Yellow("⚠️  %s has %d conflicts marked with %s; edit it and run 'devswitch status'", cfg.Src(), p.Conflicts, markerOurs)
+	default:
+		color.Yellow("⚠️  Merged %s, resolving %d conflicts", cfg.Name, p.Conflicts)
+	}
+	if err := applied.recordMerged(cfg.Name, cfg.Src(), p.Base); err != nil {
+		return err
+	}
+	if bytes.Contains(p.Content, []byte(markerOurs)) {
+		f := applied.Files[cfg.Name]
+		f.Conflicted = true
+		applied.Files[cfg.Name] = f
+	}
+	return nil
+}
+
+// hasConflictMarkers reports whether path still holds markers left by a
+// merge with --conflict=markers.
+func hasConflictMarkers(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && bytes.Contains(data, []byte(markerOurs))
+}
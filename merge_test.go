@@ -0,0 +1,278 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	const base = "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name          string
+		base          string
+		ours, theirs  string
+		policy        string
+		want          string
+		wantConflicts int
+	}{
+		{
+			name: "changes in different places",
+			base: base, ours: "A\nb\nc\nd\ne\n", theirs: "a\nb\nc\nd\nE\n",
+			policy: conflictAbort,
+			want:   "A\nb\nc\nd\nE\n",
+		},
+		{
+			name: "local insertion and profile deletion",
+			base: base, ours: "a\nb\nnew\nc\nd\ne\n", theirs: "a\nb\nc\ne\n",
+			policy: conflictAbort,
+			want:   "a\nb\nnew\nc\ne\n",
+		},
+		{
+			name: "only the profile changed",
+			base: base, ours: base, theirs: "a\nB\nc\nd\ne\n",
+			policy: conflictAbort,
+			want:   "a\nB\nc\nd\ne\n",
+		},
+		{
+			name: "identical edits on both sides",
+			base: base, ours: "a\nX\nc\nd\ne\n", theirs: "a\nX\nc\nd\ne\n",
+			policy: conflictAbort,
+			want:   "a\nX\nc\nd\ne\n",
+		},
+		{
+			name: "overlapping edits with markers",
+			base: base, ours: "a\nours\nc\nd\ne\n", theirs: "a\ntheirs\nc\nd\ne\n",
+			policy:        conflictMarkers,
+			want:          "a\n<<<<<<< local\nours\n=======\ntheirs\n>>>>>>> profile\nc\nd\ne\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "overlapping edits resolved for ours",
+			base: base, ours: "a\nours\nc\nd\nE\n", theirs: "a\ntheirs\nc\nd\ne\n",
+			policy:        conflictOurs,
+			want:          "a\nours\nc\nd\nE\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "overlapping edits resolved for theirs",
+			base: base, ours: "a\nours\nc\nd\nE\n", theirs: "a\ntheirs\nc\nd\ne\n",
+			policy:        conflictTheirs,
+			want:          "a\ntheirs\nc\nd\nE\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "two separate conflicts",
+			base: base, ours: "1\nb\nc\nd\n1\n", theirs: "2\nb\nc\nd\n2\n",
+			policy:        conflictTheirs,
+			want:          "2\nb\nc\nd\n2\n",
+			wantConflicts: 2,
+		},
+		{
+			name: "empty base with the same content on both sides",
+			base: "", ours: "x\n", theirs: "x\n",
+			policy: conflictAbort,
+			want:   "x\n",
+		},
+		{
+			name: "empty base with different content",
+			base: "", ours: "x\n", theirs: "y\n",
+			policy:        conflictOurs,
+			want:          "x\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "both sides emptied the file",
+			base: base, ours: "", theirs: "",
+			policy: conflictAbort,
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, conflicts, ok := merge3([]byte(tt.base), []byte(tt.ours), []byte(tt.theirs), tt.policy)
+			if !ok {
+				t.Fatal("merge3 refused the input")
+			}
+			if string(out) != tt.want || conflicts != tt.wantConflicts {
+				t.Errorf("got %q with %d conflicts, want %q with %d", out, conflicts, tt.want, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestMerge3RejectsBinary(t *testing.T) {
+	if _, _, ok := merge3([]byte("a\n"), []byte("a\x00\n"), []byte("b\n"), conflictAbort); ok {
+		t.Error("binary content was merged by line")
+	}
+}
+
+// mergeFixture sets up a home where .npmrc was applied with base by profile
+// prev, now holds ours, and the profile's new version is theirs.
+func mergeFixture(t *testing.T, prev string, base []byte, ours, theirs string) (*appliedState, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	cfg, _ := configByName(".npmrc")
+	if err := os.WriteFile(cfg.Src(), []byte(ours), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, ".npmrc"), []byte(theirs), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st := &appliedState{Profile: prev, Files: map[string]appliedFile{}}
+	if base == nil {
+		// applied before bases were recorded
+		st.Files[".npmrc"] = appliedFile{Path: cfg.Src(), Hash: hashBytes([]byte("old\n"))}
+	} else if err := st.recordProfile(".npmrc", cfg.Src(), base); err != nil {
+		t.Fatal(err)
+	}
+	return st, srcDir
+}
+
+func TestPlanMerges(t *testing.T) {
+	const base = "registry=a\nx=1\ny=1\nz=1\n"
+	tests := []struct {
+		name         string
+		prev         string
+		base         []byte
+		ours, theirs string
+		policy       string
+		want         *mergePlan // nil: the profile's file replaces the target
+		wantErr      string
+	}{
+		{
+			name: "target unchanged",
+			prev: "work", base: []byte(base), ours: base, theirs: "registry=b\nx=1\ny=1\nz=1\n",
+			policy: conflictAbort,
+		},
+		{
+			name: "profile unchanged keeps the local edits",
+			prev: "work", base: []byte(base), ours: "registry=local\nx=1\ny=1\nz=1\n", theirs: base,
+			policy: conflictAbort,
+			want:   &mergePlan{Keep: true, Base: []byte(base)},
+		},
+		{
+			name: "clean merge",
+			prev: "work", base: []byte(base), ours: "registry=local\nx=1\ny=1\nz=1\n", theirs: "registry=a\nx=1\ny=1\nz=2\n",
+			policy: conflictAbort,
+			want:   &mergePlan{Content: []byte("registry=local\nx=1\ny=1\nz=2\n"), Base: []byte("registry=a\nx=1\ny=1\nz=2\n")},
+		},
+		{
+			name: "identical edits",
+			prev: "work", base: []byte(base), ours: "registry=b\nx=1\ny=1\nz=1\n", theirs: "registry=b\nx=1\ny=1\nz=1\n",
+			policy: conflictAbort,
+			want:   &mergePlan{Content: []byte("registry=b\nx=1\ny=1\nz=1\n"), Base: []byte("registry=b\nx=1\ny=1\nz=1\n")},
+		},
+		{
+			name: "conflict aborts",
+			prev: "work", base: []byte(base), ours: "registry=local\nx=1\ny=1\nz=1\n", theirs: "registry=b\nx=1\ny=1\nz=1\n",
+			policy:  conflictAbort,
+			wantErr: ".npmrc (1 conflicts)",
+		},
+		{
+			name: "conflict with markers",
+			prev: "work", base: []byte(base), ours: "registry=local\nx=1\ny=1\nz=1\n", theirs: "registry=b\nx=1\ny=1\nz=1\n",
+			policy: conflictMarkers,
+			want: &mergePlan{
+				Content:   []byte("<<<<<<< local\nregistry=local\n=======\nregistry=b\n>>>>>>> profile\nx=1\ny=1\nz=1\n"),
+				Base:      []byte("registry=b\nx=1\ny=1\nz=1\n"),
+				Conflicts: 1,
+			},
+		},
+		{
+			name: "missing base replaces the target",
+			prev: "work", base: nil, ours: "registry=local\n", theirs: "registry=b\n",
+			policy: conflictAbort,
+		},
+		{
+			name: "another profile replaces the target",
+			prev: "home", base: []byte(base), ours: "registry=local\nx=1\ny=1\nz=1\n", theirs: "registry=b\nx=1\ny=1\nz=1\n",
+			policy: conflictAbort,
+		},
+		{
+			name: "binary conflict aborts",
+			prev: "work", base: []byte(base), ours: "registry=\x00\n", theirs: "registry=b\n",
+			policy:  conflictMarkers,
+			wantErr: ".npmrc (binary)",
+		},
+		{
+			name: "binary conflict resolved for theirs",
+			prev: "work", base: []byte(base), ours: "registry=\x00\n", theirs: "registry=b\n",
+			policy: conflictTheirs,
+			want:   &mergePlan{Content: []byte("registry=b\n"), Base: []byte("registry=b\n"), Conflicts: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, srcDir := mergeFixture(t, tt.prev, tt.base, tt.ours, tt.theirs)
+			plans, err := planMerges(prev, srcDir, "work", nil, tt.policy)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := plans[".npmrc"]
+			switch {
+			case tt.want == nil && got != nil:
+				t.Fatalf("got a plan %+v, want the target replaced", got)
+			case tt.want == nil:
+			case got == nil:
+				t.Fatalf("got no plan, want %+v", tt.want)
+			case got.Keep != tt.want.Keep || string(got.Content) != string(tt.want.Content) ||
+				string(got.Base) != string(tt.want.Base) || got.Conflicts != tt.want.Conflicts:
+				t.Errorf("got plan %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConflictMarkersSurviveReapply(t *testing.T) {
+	const base = "registry=a\nx=1\n"
+	prev, srcDir := mergeFixture(t, "work", []byte(base), "registry=local\nx=1\n", "registry=b\nx=1\n")
+	cfg, _ := configByName(".npmrc")
+
+	plans, err := planMerges(prev, srcDir, "work", nil, conflictMarkers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := newAppliedState("work", false)
+	if err := applyMerge(plans[".npmrc"], cfg, applied); err != nil {
+		t.Fatal(err)
+	}
+	if !applied.Files[".npmrc"].Conflicted {
+		t.Fatal("a merge with markers was not recorded as conflicted")
+	}
+	if st := driftStatus(applied); len(st) == 0 || st[0].State != fileConflicted {
+		t.Fatalf("status %+v, want .npmrc conflicted", st)
+	}
+
+	// re-applying while the markers are there refuses, unless --theirs
+	if _, err := planMerges(applied, srcDir, "work", nil, conflictMarkers); err == nil || !strings.Contains(err.Error(), "unresolved conflict markers") {
+		t.Fatalf("got error %v, want unresolved conflict markers", err)
+	}
+	plans, err = planMerges(applied, srcDir, "work", nil, conflictTheirs)
+	if err != nil || plans[".npmrc"] != nil {
+		t.Fatalf("--theirs: got plan %v and error %v, want the target replaced", plans[".npmrc"], err)
+	}
+
+	// once resolved, the file counts as a local edit of the new profile content
+	if err := os.WriteFile(cfg.Src(), []byte("registry=local\nx=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if st := driftStatus(applied); st[0].State != fileModified {
+		t.Fatalf("status %+v, want .npmrc modified", st)
+	}
+	plans, err = planMerges(applied, srcDir, "work", nil, conflictAbort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := plans[".npmrc"]; p == nil || !p.Keep {
+		t.Fatalf("got plan %+v, want the resolved edits kept", p)
+	}
+}
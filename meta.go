@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultStaleAfter is how long a profile can go unused before list marks
+// it stale.
+const defaultStaleAfter = "90d"
+
+// profileTimes are when a profile was created and last applied on this
+// machine. Description, tags and owner live in the profile's manifest and
+// travel with it; these do not.
+type profileTimes struct {
+	Created     time.Time `json:"created"`
+	LastApplied time.Time `json:"last_applied"`
+}
+
+func profileTimesPath() string {
+	return filepath.Join(devDir(), "profiles.json")
+}
+
+func loadProfileTimes() (map[string]*profileTimes, error) {
+	times := map[string]*profileTimes{}
+	data, err := os.ReadFile(profileTimesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return times, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &times); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", profileTimesPath(), err)
+	}
+	return times, nil
+}
+
+// updateProfileTimes changes the recorded times of profiles.
+func updateProfileTimes(update func(times map[string]*profileTimes)) error {
+	times, err := loadProfileTimes()
+	if err != nil {
+		return err
+	}
+	update(times)
+	data, err := json.MarshalIndent(times, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(profileTimesPath(), data, 0o644)
+}
+
+func timesOf(times map[string]*profileTimes, name string) *profileTimes {
+	if times[name] == nil {
+		times[name] = &profileTimes{}
+	}
+	return times[name]
+}
+
+func markCreated(name string) error {
+	return updateProfileTimes(func(times map[string]*profileTimes) {
+		*timesOf(times, name) = profileTimes{Created: time.Now()}
+	})
+}
+
+// markApplied records the apply of every member of a profile spec.
+func markApplied(spec string) error {
+	now := time.Now()
+	return updateProfileTimes(func(times map[string]*profileTimes) {
+		for _, m := range splitProfileSpec(spec) {
+			timesOf(times, m).LastApplied = now
+		}
+	})
+}
+
+func renameProfileTimes(oldName, newName string) error {
+	return updateProfileTimes(func(times map[string]*profileTimes) {
+		if t, ok := times[oldName]; ok {
+			times[newName] = t
+			delete(times, oldName)
+		}
+	})
+}
+
+func forgetProfileTimes(name string) error {
+	return updateProfileTimes(func(times map[string]*profileTimes) {
+		delete(times, name)
+	})
+}
+
+// initProfileMeta stores the metadata given to create in the new profile's
+// manifest and records when it was created.
+func initProfileMeta(c *cli.Context, profPath string) error {
+	desc, tags, owner := c.String("description"), c.StringSlice("tag"), c.String("owner")
+	if desc != "" || len(tags) > 0 || owner != "" {
+		err := editManifest(profPath, func(root *yaml.Node) error {
+			if desc != "" {
+				if err := setManifestKey(root, "description", desc); err != nil {
+					return err
+				}
+			}
+			if len(tags) > 0 {
+				if err := setManifestKey(root, "tags", tags); err != nil {
+					return err
+				}
+			}
+			if owner != "" {
+				return setManifestKey(root, "owner", owner)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return markCreated(filepath.Base(profPath))
+}
+
+// profileInfo is what list and show report about a profile.
+type profileInfo struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	Extends     []string   `json:"extends,omitempty"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+	LastApplied *time.Time `json:"last_applied,omitempty"`
+	Active      bool       `json:"active"`
+	Stale       bool       `json:"stale"`
+}
+
+// profileFileTimes returns the oldest and newest modification time of the
+// files in a profile directory.
+func profileFileTimes(profPath string) (oldest, newest time.Time) {
+	filepath.WalkDir(profPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		mt := info.ModTime()
+		if oldest.IsZero() || mt.Before(oldest) {
+			oldest = mt
+		}
+		if mt.After(newest) {
+			newest = mt
+		}
+		return nil
+	})
+	return oldest, newest
+}
+
+// loadProfileInfo gathers the metadata of a profile. A profile is stale when
+// it is not active and was last applied, or created if never applied, before
+// staleSince.
+func loadProfileInfo(name string, times map[string]*profileTimes, active string, staleSince time.Time) (*profileInfo, error) {
+	profPath := filepath.Join(profilesDir(), name)
+	m, err := loadManifest(profPath)
+	if err != nil {
+		return nil, err
+	}
+	info := &profileInfo{
+		Name:        name,
+		Description: m.Description,
+		Tags:        m.Tags,
+		Owner:       m.Owner,
+		Extends:     m.Extends,
+		Active:      hasMember(active, name),
+	}
+	oldest, newest := profileFileTimes(profPath)
+	info.Created, info.Updated = oldest, newest
+	t := times[name]
+	if t != nil && !t.Created.IsZero() {
+		info.Created = t.Created
+	}
+	if t != nil && !t.LastApplied.IsZero() {
+		applied := t.LastApplied
+		info.LastApplied = &applied
+	}
+	if info.Updated.IsZero() {
+		info.Updated = info.Created
+	}
+
+	last := info.Created
+	if info.LastApplied != nil {
+		last = *info.LastApplied
+	}
+	info.Stale = !info.Active && last.Before(staleSince)
+	return info, nil
+}
+
+func (p *profileInfo) hasTags(tags []string) bool {
+	for _, want := range tags {
+		found := false
+		for _, t := range p.Tags {
+			if strings.EqualFold(t, want) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ago renders how long ago t was, roughly.
+func ago(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	case d < 60*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+func cmdList(c *cli.Context) error {
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	names, err := profileNames()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		boxInfo("No Profiles Found", "Create your first profile with 'devswitch create <name>'")
+		return nil
+	}
+	staleSince, err := parseSince(c.String("stale-after"))
+	if err != nil {
+		return fmt.Errorf("invalid --stale-after %q, use a duration like 90d", c.String("stale-after"))
+	}
+	times, err := loadProfileTimes()
+	if err != nil {
+		return err
+	}
+	active, _ := readCurrentProfile()
+
+	var infos []*profileInfo
+	for _, name := range names {
+		info, err := loadProfileInfo(name, times, active, staleSince)
+		if err != nil {
+			return err
+		}
+		if info.hasTags(c.StringSlice("tag")) {
+			infos = append(infos, info)
+		}
+	}
+
+	if c.Bool("json") {
+		data, err := json.MarshalIndent(infos, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if len(infos) == 0 {
+		boxInfo("No Profiles Found", fmt.Sprintf("No profile is tagged %s", strings.Join(c.StringSlice("tag"), ", ")))
+		return nil
+	}
+
+	width := 0
+	for _, info := range infos {
+		width = max(width, len(info.Name))
+	}
+	var b strings.Builder
+	stale := 0
+	for _, info := range infos {
+		marker := " "
+		if info.Active {
+			marker = "●"
+		}
+		used := "never applied"
+		if info.LastApplied != nil {
+			used = "applied " + ago(*info.LastApplied)
+		}
+		if info.Stale {
+			used += " (stale)"
+			stale++
+		}
+		fmt.Fprintf(&b, "\n %s %-*s  %s", marker, width, info.Name, used)
+		if info.Description != "" {
+			fmt.Fprintf(&b, "\n   %-*s  %s", width, "", info.Description)
+		}
+		if len(info.Tags) > 0 {
+			fmt.Fprintf(&b, "\n   %-*s  tags: %s", width, "", strings.Join(info.Tags, ", "))
+		}
+	}
+	b.WriteString("\n\n● active")
+	if stale > 0 {
+		fmt.Fprintf(&b, ", %d stale (not applied for %s)", stale, c.String("stale-after"))
+	}
+	boxInfo("Available Profiles", b.String())
+	return nil
+}
+
+// showDetails is the metadata part of show.
+func showDetails(name string) (string, error) {
+	times, err := loadProfileTimes()
+	if err != nil {
+		return "", err
+	}
+	active, _ := readCurrentProfile()
+	staleSince, _ := parseSince(defaultStaleAfter)
+	info, err := loadProfileInfo(name, times, active, staleSince)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if info.Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", info.Description)
+	}
+	field := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%-13s %s\n", label+":", value)
+		}
+	}
+	field("Owner", info.Owner)
+	field("Tags", strings.Join(info.Tags, ", "))
+	field("Created", info.Created.Local().Format("2006-01-02 15:04"))
+	field("Updated", info.Updated.Local().Format("2006-01-02 15:04"))
+	applied := "never"
+	if info.LastApplied != nil {
+		applied = fmt.Sprintf("%s (%s)", info.LastApplied.Local().Format("2006-01-02 15:04"), ago(*info.LastApplied))
+	}
+	field("Last applied", applied)
+	switch {
+	case info.Active:
+		field("State", "active")
+	case info.Stale:
+		field("State", "stale")
+	}
+	return b.String(), nil
+}
+
+// fileInventory lists the files of a profile directory with their size and
+// permissions, subdirectories included.
+func fileInventory(profPath string) (string, error) {
+	type entry struct {
+		name string
+		size int64
+		mode fs.FileMode
+	}
+	var entries []entry
+	err := filepath.WalkDir(profPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(profPath, p)
+		entries = append(entries, entry{filepath.ToSlash(rel), info.Size(), info.Mode().Perm()})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "  • %-24s %8s  %04o\n", e.name, humanSize(e.size), e.mode)
+	}
+	return b.String(), nil
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+}
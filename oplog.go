@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// Results of a logged operation.
+const (
+	opOK        = "ok"
+	opError     = "error"
+	opCancelled = "cancelled"
+)
+
+// opEvent is one line of the operation log.
+type opEvent struct {
+	Time    time.Time    `json:"time"`
+	User    string       `json:"user"`
+	Host    string       `json:"host"`
+	Command string       `json:"command"`
+	Args    []string     `json:"args,omitempty"`
+	From    string       `json:"from,omitempty"`
+	To      string       `json:"to,omitempty"`
+	Backup  string       `json:"backup,omitempty"`
+	Files   []opFile     `json:"files,omitempty"`
+	Hooks   []hookResult `json:"hooks,omitempty"`
+	Result  string       `json:"result"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// opFile is a file an operation wrote, with sha256 hashes of its content
+// before and after. Before is empty for files that did not exist.
+type opFile struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after"`
+}
+
+// activeOp is the event of the running command; the note* helpers add to
+// it and do nothing when the command is not logged.
+var activeOp *opEvent
+
+func opLogPath() string {
+	return filepath.Join(logsDir(), "operations.jsonl")
+}
+
+func logsDir() string {
+	return filepath.Join(devDir(), "logs")
+}
+
+// newOpEvent starts the event of an operation by the current user.
+func newOpEvent(command string) *opEvent {
+	host, _ := os.Hostname()
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	return &opEvent{
+		Time:    time.Now(),
+		User:    username,
+		Host:    host,
+		Command: command,
+		Args:    os.Args[1:],
+	}
+}
+
+// logged wraps a command so that every run appends an event to the
+// operation log, whether it succeeds or not.
+func logged(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		activeOp = newOpEvent(c.Command.Name)
+		err := action(c)
+
+		activeOp.Result = opOK
+		if err != nil {
+			activeOp.Result = opError
+			var veto *hookVetoError
+			if errors.As(err, &veto) {
+				activeOp.Result = opCancelled
+			}
+			activeOp.Error = err.Error()
+		}
+		if lerr := appendOpLog(activeOp); lerr != nil {
+			color.Yellow("⚠️  Could not write operation log: %v", lerr)
+		}
+		activeOp = nil
+		return err
+	}
+}
+
+func appendOpLog(e *opEvent) error {
+	if err := os.MkdirAll(logsDir(), 0o700); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(opLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func noteTransition(from, to string) {
+	if activeOp != nil {
+		activeOp.From, activeOp.To = from, to
+	}
+}
+
+// noteFileChange records that path was written; before is the hash it had
+// beforehand. Writes that left the content unchanged are not recorded.
+func noteFileChange(name, path, before string) {
+	if activeOp == nil {
+		return
+	}
+	after, _ := contentHash(path)
+	if after == before {
+		return
+	}
+	activeOp.Files = append(activeOp.Files, opFile{Name: name, Path: path, Before: before, After: after})
+}
+
+// noteBackup records the backup an operation made or restored. A plain
+// backup also lists the files it saved.
+func noteBackup(dir string) {
+	if activeOp == nil {
+		return
+	}
+	activeOp.Backup = filepath.Base(dir)
+	if activeOp.Command != "backup" {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			noteFileChange(e.Name(), filepath.Join(dir, e.Name()), "")
+		}
+	}
+}
+
+// noteProfileFiles records the files of a newly created profile.
+func noteProfileFiles(profile string) {
+	if activeOp == nil {
+		return
+	}
+	activeOp.To = profile
+	root := filepath.Join(profilesDir(), profile)
+	filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		noteFileChange(filepath.ToSlash(rel), p, "")
+		return nil
+	})
+}
+
+func noteHook(res hookResult) {
+	if activeOp != nil {
+		activeOp.Hooks = append(activeOp.Hooks, res)
+	}
+}
+
+// readOpLog returns all logged events, oldest first. Lines that cannot be
+// parsed are skipped.
+func readOpLog() ([]opEvent, error) {
+	f, err := os.Open(opLogPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []opEvent
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for sc.Scan() {
+		var e opEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err == nil {
+			events = append(events, e)
+		}
+	}
+	return events, sc.Err()
+}
+
+// parseSince accepts a duration such as 36h or 7d, or a date (2006-01-02).
+func parseSince(s string) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, use a duration like 7d or 12h, or a date like 2024-01-31", s)
+}
+
+// involves reports whether an event switched from or to profile, alone or
+// as part of a composition.
+func (e *opEvent) involves(profile string) bool {
+	for _, name := range []string{e.From, e.To} {
+		for _, m := range splitProfileSpec(name) {
+			if m == profile {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func shortHash(h string) string {
+	if h == "" {
+		return "(new)"
+	}
+	if len(h) > 10 {
+		return h[:10]
+	}
+	return h
+}
+
+func cmdHistory(c *cli.Context) error {
+	events, err := readOpLog()
+	if err != nil {
+		return err
+	}
+	var since time.Time
+	if s := c.String("since"); s != "" {
+		if since, err = parseSince(s); err != nil {
+			return err
+		}
+	}
+
+	var matched []opEvent
+	for _, e := range events {
+		if p := c.String("profile"); p != "" && !e.involves(p) {
+			continue
+		}
+		if cmd := c.String("command"); cmd != "" && e.Command != cmd {
+			continue
+		}
+		if c.Bool("failed") && e.Result == opOK {
+			continue
+		}
+		if !since.IsZero() && e.Time.Before(since) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	if n := c.Int("limit"); n > 0 && len(matched) > n {
+		matched = matched[len(matched)-n:]
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range matched {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(matched) == 0 {
+		boxInfo("No History", "No logged operations match")
+		return nil
+	}
+
+	for _, e := range matched {
+		target := e.To
+		if e.From != "" || (e.To != "" && e.Command != "create") {
+			target = fmt.Sprintf("%s → %s", firstNonEmpty(e.From, "(none)"), firstNonEmpty(e.To, "(none)"))
+		}
+		result := color.GreenString(e.Result)
+		switch e.Result {
+		case opError:
+			result = color.RedString(e.Result)
+		case opCancelled:
+			result = color.YellowString(e.Result)
+		}
+		fmt.Printf("%s  %-8s %-28s %2d files  %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Command, target, len(e.Files), result)
+		if !c.Bool("verbose") {
+			continue
+		}
+		if len(e.Args) > 0 {
+			fmt.Printf("    args:   %s\n", strings.Join(e.Args, " "))
+		}
+		fmt.Printf("    by:     %s@%s\n", e.User, e.Host)
+		if e.Backup != "" {
+			fmt.Printf("    backup: %s\n", e.Backup)
+		}
+		for _, f := range e.Files {
+			fmt.Printf("    • %-20s %s → %s  %s\n", f.Name, shortHash(f.Before), shortHash(f.After), f.Path)
+		}
+		for _, h := range e.Hooks {
+			status := "ok"
+			if h.Error != "" {
+				status = h.Error
+			}
+			fmt.Printf("    🪝 %s %s (%s): %s\n", h.Event, h.Hook, h.Profile, status)
+			for _, line := range strings.Split(strings.TrimRight(h.Output, "\n"), "\n") {
+				if line != "" {
+					fmt.Printf("       | %s\n", line)
+				}
+			}
+		}
+		if e.Error != "" {
+			color.Red("    error:  %s", e.Error)
+		}
+	}
+	return nil
+}
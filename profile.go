@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// trashDir holds deleted profiles until they are removed by hand.
+func trashDir() string {
+	return filepath.Join(devDir(), "trash")
+}
+
+// existingProfile checks that name is a single existing profile.
+func existingProfile(name string) (string, error) {
+	if isComposite(name) {
+		return "", fmt.Errorf("%s is a composition, not a profile", name)
+	}
+	if err := checkName("profile", name); err != nil {
+		return "", err
+	}
+	profPath := filepath.Join(profilesDir(), name)
+	if _, err := os.Stat(profPath); err != nil {
+		return "", fmt.Errorf("profile %s does not exist", name)
+	}
+	return profPath, nil
+}
+
+// renameMember replaces oldName with newName in a profile spec such as work+kube.
+func renameMember(spec, oldName, newName string) (string, bool) {
+	members := splitProfileSpec(spec)
+	found := false
+	for i, m := range members {
+		if m == oldName {
+			members[i] = newName
+			found = true
+		}
+	}
+	return strings.Join(members, profileSeparator), found
+}
+
+func hasMember(spec, name string) bool {
+	for _, m := range splitProfileSpec(spec) {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dependents returns the profiles that extend name directly.
+func dependents(name string) ([]string, error) {
+	names, err := profileNames()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, p := range names {
+		m, err := loadManifest(filepath.Join(profilesDir(), p))
+		if err != nil {
+			continue
+		}
+		for _, parent := range m.Extends {
+			if parent == name {
+				out = append(out, p)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// rewriteExtends renames a parent in the extends list of profile's
+// manifest.
+func rewriteExtends(profile, oldName, newName string) error {
+	return editManifest(filepath.Join(profilesDir(), profile), func(root *yaml.Node) error {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value != "extends" {
+				continue
+			}
+			for _, item := range root.Content[i+1].Content {
+				if item.Value == oldName {
+					item.Value = newName
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// renameState points the current profile, the apply record and directory
+// bindings at the new name.
+func renameState(oldName, newName string) error {
+	if current, err := readCurrentProfile(); err == nil {
+		if spec, ok := renameMember(current, oldName, newName); ok {
+			if err := writeCurrentProfile(spec); err != nil {
+				return err
+			}
+			if st, err := loadAppliedState(); err == nil && st != nil {
+				st.Profile, _ = renameMember(st.Profile, oldName, newName)
+				if err := st.save(); err != nil {
+					return err
+				}
+			}
+			if comp := readOrigins(); comp != nil {
+				for i, p := range comp.Profiles {
+					if p == oldName {
+						comp.Profiles[i] = newName
+					}
+				}
+				for i, p := range comp.Prefer {
+					if p == oldName {
+						comp.Prefer[i] = newName
+					}
+				}
+				for f, p := range comp.Files {
+					if p == oldName {
+						comp.Files[f] = newName
+					}
+				}
+				if err := writeOrigins(comp); err != nil {
+					return err
+				}
+			}
+			if err := bumpGeneration(); err != nil {
+				return err
+			}
+		}
+	}
+
+	bindings, err := loadBindings()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for i := range bindings.Bindings {
+		if spec, ok := renameMember(bindings.Bindings[i].Profile, oldName, newName); ok {
+			bindings.Bindings[i].Profile = spec
+			changed = true
+		}
+	}
+	if changed {
+		return bindings.save()
+	}
+	return nil
+}
+
+func cmdProfileRename(c *cli.Context) error {
+	oldName, newName := c.Args().Get(0), c.Args().Get(1)
+	oldPath, err := existingProfile(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := newProfilePath(newName)
+	if err != nil {
+		return err
+	}
+	noteTransition(oldName, newName)
+	deps, err := dependents(oldName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", oldName, err)
+	}
+	for _, dep := range deps {
+		if err := rewriteExtends(dep, oldName, newName); err != nil {
+			return fmt.Errorf("renamed, but could not update %s, which extends it: %v", dep, err)
+		}
+	}
+	if err := os.Rename(machineVarsPath(oldName), machineVarsPath(newName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removeBuild(oldName)
+	if err := renameState(oldName, newName); err != nil {
+		return err
+	}
+	if err := renameProfileTimes(oldName, newName); err != nil {
+		return err
+	}
+
+	info := fmt.Sprintf("%s → %s", oldName, newName)
+	if len(deps) > 0 {
+		info += fmt.Sprintf("\n\nUpdated extends in %s", strings.Join(deps, ", "))
+	}
+	boxInfo("Profile Renamed", info)
+	return nil
+}
+
+func cmdProfileCopy(c *cli.Context) error {
+	src, dst := c.Args().Get(0), c.Args().Get(1)
+	srcPath, err := existingProfile(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := newProfilePath(dst)
+	if err != nil {
+		return err
+	}
+	noteTransition(src, dst)
+
+	// copy next to the profiles, then move into place in one step
+	tmp, err := os.MkdirTemp(profilesDir(), ".copy-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(srcPath, tmp); err != nil {
+		return fmt.Errorf("failed to copy %s: %v", src, err)
+	}
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dstPath); err != nil {
+		return err
+	}
+	if vars, err := loadMachineVars(src); err == nil && len(vars) > 0 {
+		if err := saveMachineVars(dst, vars); err != nil {
+			return err
+		}
+	}
+	if err := markCreated(dst); err != nil {
+		return err
+	}
+	noteProfileFiles(dst)
+	boxInfo("Profile Copied", fmt.Sprintf("%s → %s", src, dst))
+	return nil
+}
+
+func cmdProfileDelete(c *cli.Context) error {
+	name := c.Args().First()
+	profPath, err := existingProfile(name)
+	if err != nil {
+		return err
+	}
+	noteTransition(name, "")
+	deps, err := dependents(name)
+	if err != nil {
+		return err
+	}
+	if len(deps) > 0 {
+		return fmt.Errorf("%s is extended by %s; change those first", name, strings.Join(deps, ", "))
+	}
+	if current, err := readCurrentProfile(); err == nil && !c.Bool("force") {
+		if hasMember(current, name) {
+			return fmt.Errorf("%s is active; apply another profile first or use --force", name)
+		}
+	}
+	bindings, err := loadBindings()
+	if err != nil {
+		return err
+	}
+	var bound []string
+	kept := bindings.Bindings[:0]
+	for _, bd := range bindings.Bindings {
+		if hasMember(bd.Profile, name) {
+			bound = append(bound, bd.Path)
+			continue
+		}
+		kept = append(kept, bd)
+	}
+	if len(bound) > 0 && !c.Bool("force") {
+		return fmt.Errorf("%s is bound to %s; unbind it first or use --force", name, strings.Join(bound, ", "))
+	}
+
+	if err := os.MkdirAll(trashDir(), 0o700); err != nil {
+		return err
+	}
+	dst := filepath.Join(trashDir(), name+"-"+time.Now().Format("20060102-150405"))
+	if err := os.Rename(profPath, dst); err != nil {
+		return fmt.Errorf("failed to move %s to the trash: %v", name, err)
+	}
+	if len(bound) > 0 {
+		bindings.Bindings = kept
+		if err := bindings.save(); err != nil {
+			return err
+		}
+	}
+	removeBuild(name)
+	if err := forgetProfileTimes(name); err != nil {
+		return err
+	}
+
+	info := fmt.Sprintf("Moved %s to %s\n\nRestore it with:\n  mv %s %s", name, dst, dst, profPath)
+	if len(bound) > 0 {
+		info += fmt.Sprintf("\n\nRemoved bindings of %s", strings.Join(bound, ", "))
+	}
+	boxInfo("Profile Deleted", info)
+	return nil
+}
+
+// editorCommand is the user's editor with its arguments.
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+func cmdProfileEdit(c *cli.Context) error {
+	name := c.Args().Get(0)
+	profPath, err := existingProfile(name)
+	if err != nil {
+		return err
+	}
+	file := c.Args().Get(1)
+	if file == "" {
+		file = manifestName
+	}
+	if !filepath.IsLocal(file) {
+		return fmt.Errorf("%s is not a file inside the profile", file)
+	}
+	dst := filepath.Join(profPath, file)
+	original, err := os.ReadFile(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(dst); err == nil {
+		perm = info.Mode().Perm()
+	}
+	noteTransition(name, name)
+
+	// edit a copy with the same name, so the editor picks the right mode
+	tmpDir, err := os.MkdirTemp("", "devswitch-edit-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmp := filepath.Join(tmpDir, filepath.Base(file))
+	if err := os.WriteFile(tmp, original, 0o600); err != nil {
+		return err
+	}
+
+	editor := editorCommand()
+	interactive := isatty.IsTerminal(os.Stdin.Fd())
+	r := bufio.NewReader(os.Stdin)
+	var edited []byte
+	for {
+		cmd := exec.Command(editor[0], append(editor[1:], tmp)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("editor %s failed: %v", editor[0], err)
+		}
+		if edited, err = os.ReadFile(tmp); err != nil {
+			return err
+		}
+		verr := checkProfileFile(file, edited).Err
+		if verr == nil {
+			break
+		}
+		color.Red("❌ %s: %v", file, verr)
+		if !interactive {
+			return fmt.Errorf("%s not saved: %v", file, verr)
+		}
+		again, err := confirm(r, "Edit again? (no discards the changes)", true)
+		if err != nil {
+			return err
+		}
+		if !again {
+			color.Yellow("Discarded changes to %s", file)
+			return nil
+		}
+	}
+
+	if bytes.Equal(edited, original) {
+		color.Blue("No changes to %s", file)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	before, _ := contentHash(dst)
+	if err := writeFileAtomic(dst, edited, perm); err != nil {
+		return err
+	}
+	noteFileChange(file, dst, before)
+	color.Green("✅ Saved %s in %s", file, name)
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/mattn/go-isatty"
+	"gopkg.in/yaml.v3"
+)
+
+// templateSuffix marks profile files that are rendered at apply time;
+// .gitconfig.tmpl is applied as .gitconfig.
+const templateSuffix = ".tmpl"
+
+// templatePrompt declares a variable that is asked for once per machine.
+type templatePrompt struct {
+	Name    string `yaml:"name"`
+	Message string `yaml:"message,omitempty"`
+	Default string `yaml:"default,omitempty"`
+}
+
+// templateData is what profile templates see as dot.
+type templateData struct {
+	Profile  string
+	Vars     map[string]string
+	Env      map[string]string
+	Hostname string
+	OS       string
+	Arch     string
+	User     string
+	Home     string
+}
+
+func machineVarsPath(profile string) string {
+	return filepath.Join(devDir(), "vars", profile+".yaml")
+}
+
+// loadMachineVars returns the answers given on this machine for profile.
+func loadMachineVars(profile string) (map[string]string, error) {
+	vars := map[string]string{}
+	data, err := os.ReadFile(machineVarsPath(profile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return vars, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", machineVarsPath(profile), err)
+	}
+	return vars, nil
+}
+
+func saveMachineVars(profile string, vars map[string]string) error {
+	data, err := yaml.Marshal(vars)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(machineVarsPath(profile), data, 0o600)
+}
+
+// templateVars combines manifest variables with the answers stored for this
+// machine, which take precedence.
+func templateVars(profile string, m *profileManifest) (map[string]string, error) {
+	vars := map[string]string{}
+	for k, v := range m.Vars {
+		vars[k] = v
+	}
+	machine, err := loadMachineVars(profile)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range machine {
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// promptTemplateVars stores --var overrides and asks for prompted variables
+// that have no value yet on this machine. Without a terminal, missing
+// values are an error rather than a hang.
+func promptTemplateVars(profile string, overrides []string) error {
+	m, err := loadResolvedManifest(profile)
+	if err != nil {
+		return err
+	}
+	machine, err := loadMachineVars(profile)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, o := range overrides {
+		k, v, ok := strings.Cut(o, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("--var expects name=value, got %q", o)
+		}
+		machine[k] = v
+		changed = true
+	}
+
+	interactive := isatty.IsTerminal(os.Stdin.Fd())
+	reader := bufio.NewReader(os.Stdin)
+	for _, p := range m.Prompts {
+		if _, ok := machine[p.Name]; ok {
+			continue
+		}
+		if _, ok := m.Vars[p.Name]; ok {
+			continue
+		}
+		if !interactive {
+			return fmt.Errorf("profile %s needs a value for %q, pass --var %s=...", profile, p.Name, p.Name)
+		}
+		answer, err := ask(reader, promptMessage(p), p.Default)
+		if err != nil {
+			return err
+		}
+		machine[p.Name] = answer
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return saveMachineVars(profile, machine)
+}
+
+func promptMessage(p templatePrompt) string {
+	if p.Message != "" {
+		return p.Message
+	}
+	return p.Name
+}
+
+// ask prints a question and reads one line, falling back to def when the
+// answer is empty.
+func ask(r *bufio.Reader, question, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func secretsDir() string {
+	return filepath.Join(devDir(), "secrets")
+}
+
+// lookupSecret resolves a secret from $DEVSWITCH_SECRET_<NAME> or from
+// ~/.devswitch/secrets/<name>, so secrets never have to live in a profile.
+func lookupSecret(name string) (string, error) {
+	envName := "DEVSWITCH_SECRET_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+	if v, ok := os.LookupEnv(envName); ok {
+		return v, nil
+	}
+	data, err := os.ReadFile(filepath.Join(secretsDir(), name))
+	if err != nil {
+		return "", fmt.Errorf("secret %q not found: set %s or create %s", name, envName, filepath.Join(secretsDir(), name))
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var templateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"default": func(def, v string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	},
+	"secret": lookupSecret,
+}
+
+func newTemplateData(profile string, vars map[string]string) *templateData {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+	host, _ := os.Hostname()
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	return &templateData{
+		Profile:  profile,
+		Vars:     vars,
+		Env:      env,
+		Hostname: host,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		User:     username,
+		Home:     homeDir(),
+	}
+}
+
+// renderTemplate executes a profile template. Unknown variables are an
+// error so a typo cannot silently produce an empty value.
+func renderTemplate(name string, content []byte, data *templateData) ([]byte, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// hasTemplates reports whether a profile directory contains templates.
+func hasTemplates(dir string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+templateSuffix))
+	return len(matches) > 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("DEVSWITCH_TEST_EDITOR", "vim")
+	t.Setenv("DEVSWITCH_SECRET_NPM_TOKEN", "s3cret")
+	if err := os.MkdirAll(secretsDir(), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(secretsDir(), "gh.token"), []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data := newTemplateData("work", map[string]string{"email": "ann@work.example", "empty": ""})
+
+	tests := []struct {
+		name, in, want string
+	}{
+		{"vars and profile", "{{.Profile}} {{.Vars.email}}", "work ann@work.example"},
+		{"env function", `{{env "DEVSWITCH_TEST_EDITOR"}}`, "vim"},
+		{"env map", `{{.Env.DEVSWITCH_TEST_EDITOR}}`, "vim"},
+		{"env function on an unset variable", `[{{env "DEVSWITCH_TEST_UNSET"}}]`, "[]"},
+		{"default keeps a value", `{{default "nano" (env "DEVSWITCH_TEST_EDITOR")}}`, "vim"},
+		{"default replaces an empty value", `{{.Vars.empty | default "none"}}`, "none"},
+		{"default on an unset variable", `{{env "DEVSWITCH_TEST_UNSET" | default "nano"}}`, "nano"},
+		{"secret from the environment", `{{secret "npm-token"}}`, "s3cret"},
+		{"secret from a file, without the newline", `{{secret "gh.token"}}`, "from-file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := renderTemplate("test.tmpl", []byte(tt.in), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	data := newTemplateData("work", map[string]string{"email": "ann@work.example"})
+
+	tests := []struct {
+		name, in, want string
+	}{
+		{"missing var", "{{.Vars.emial}}", `map has no entry for key "emial"`},
+		{"missing env entry", "{{.Env.DEVSWITCH_TEST_UNSET}}", `map has no entry for key "DEVSWITCH_TEST_UNSET"`},
+		{"unknown field", "{{.Hostnme}}", "can't evaluate field Hostnme"},
+		{"missing secret", `{{secret "nope"}}`, "secret \"nope\" not found: set DEVSWITCH_SECRET_NOPE"},
+		{"syntax error", "{{.Vars.email", "unclosed action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderTemplate("test.tmpl", []byte(tt.in), data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
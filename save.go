@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v2"
+)
+
+// saveItem is one target file to copy back into a profile.
+type saveItem struct {
+	cfg     configFile
+	member  string // profile that receives the file
+	profile []byte // the profile's current content, nil if it has none
+	local   []byte
+	perm    os.FileMode
+}
+
+// printDiff shows a unified diff with additions and removals colored.
+func printDiff(diff string) {
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			color.New(color.Bold).Println(line)
+		case strings.HasPrefix(line, "@@"):
+			color.Cyan(line)
+		case strings.HasPrefix(line, "+"):
+			color.Green(line)
+		case strings.HasPrefix(line, "-"):
+			color.Red(line)
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+// configByName finds a detected config file by name, accepting the short
+// forms --only uses (gitconfig for .gitconfig).
+func configByName(name string) (configFile, bool) {
+	for _, cfg := range detectConfigFiles() {
+		if name == cfg.Name || "."+name == cfg.Name {
+			return cfg, true
+		}
+	}
+	return configFile{}, false
+}
+
+// saveTarget decides which profile receives the file name. In a composed
+// profile that is the member it came from; new files go to the member
+// named on the command line or the first one.
+func saveTarget(active, requested, name string) (string, error) {
+	members := splitProfileSpec(active)
+	if requested != "" {
+		for _, m := range members {
+			if m == requested {
+				if origins := readOrigins(); len(members) > 1 && origins != nil {
+					if from, ok := origins.Files[name]; ok && from != requested {
+						return "", fmt.Errorf("%s was applied from %s, not %s", name, from, requested)
+					}
+				}
+				return requested, nil
+			}
+		}
+		return "", fmt.Errorf("%s is not the active profile (%s); save copies edits back into the active profile", requested, active)
+	}
+	if len(members) > 1 {
+		if origins := readOrigins(); origins != nil {
+			if from, ok := origins.Files[name]; ok {
+				return from, nil
+			}
+		}
+	}
+	return members[0], nil
+}
+
+// prepareSave checks that a file can be written back into member: it must be
+// a plain file of that profile, not rendered from a template or merged from
+// layers, and the profile must still hold what was applied.
+func prepareSave(st *appliedState, cfg configFile, member string, force bool) (*saveItem, error) {
+	local, err := os.ReadFile(cfg.Src())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.Name, err)
+	}
+	item := &saveItem{cfg: cfg, member: member, local: local, perm: 0o644}
+	if info, err := os.Stat(cfg.Src()); err == nil {
+		item.perm = info.Mode().Perm()
+	}
+
+	if _, err := os.Stat(filepath.Join(profilesDir(), member, cfg.Name+templateSuffix)); err == nil {
+		return nil, fmt.Errorf("%s is rendered from %s%s in %s; edit the template instead", cfg.Name, cfg.Name, templateSuffix, member)
+	}
+	rp, err := resolveProfile(member)
+	if err != nil {
+		return nil, err
+	}
+	for _, rf := range rp.Files {
+		if rf.Name != cfg.Name {
+			continue
+		}
+		if rf.Mode != mergeReplace && len(rf.Sources) > 1 {
+			return nil, fmt.Errorf("%s is merged from %s; save it into one of them by hand", cfg.Name, strings.Join(rf.Sources, ", "))
+		}
+		item.profile = rf.Content
+		item.perm = rf.Perm
+	}
+
+	if rec, ok := st.Files[cfg.Name]; ok && rec.Conflicted && bytes.Contains(local, []byte(markerOurs)) {
+		return nil, fmt.Errorf("%s still has conflict markers; resolve them before saving", cfg.Name)
+	}
+	if rec, ok := st.Files[cfg.Name]; ok && !force {
+		if item.profile == nil {
+			return nil, fmt.Errorf("%s was removed from profile %s since it was applied; use --force to save it anyway", cfg.Name, member)
+		}
+		if hashBytes(item.profile) != rec.profileHash() {
+			return nil, fmt.Errorf("%s changed in profile %s since it was applied; re-apply or use --force to overwrite it", cfg.Name, member)
+		}
+	}
+	return item, nil
+}
+
+// write copies the local file into the profile and records that the target
+// matches the profile again.
+func (item *saveItem) write(st *appliedState) error {
+	dst := filepath.Join(profilesDir(), item.member, item.cfg.Name)
+	before, _ := contentHash(dst)
+	if err := os.WriteFile(dst, item.local, item.perm); err != nil {
+		return fmt.Errorf("failed to save %s: %v", item.cfg.Name, err)
+	}
+	noteFileChange(item.cfg.Name, dst, before)
+	return st.record(item.cfg.Name, item.cfg.Src())
+}
+
+func cmdSave(c *cli.Context) error {
+	active, err := readCurrentProfile()
+	if err != nil {
+		return fmt.Errorf("no active profile; apply one first")
+	}
+	st, err := loadAppliedState()
+	if err != nil {
+		return err
+	}
+	if st == nil || st.Profile != active {
+		return fmt.Errorf("no record of applying %s; re-apply it before saving", active)
+	}
+
+	var cfgs []configFile
+	if only := c.String("only"); only != "" {
+		for _, part := range strings.Split(only, ",") {
+			part = strings.TrimSpace(part)
+			cfg, ok := configByName(part)
+			if !ok {
+				return fmt.Errorf("unknown config file %s", part)
+			}
+			if _, err := os.Stat(cfg.Src()); err != nil {
+				return fmt.Errorf("%s does not exist", cfg.Src())
+			}
+			cfgs = append(cfgs, cfg)
+		}
+	} else {
+		for _, f := range driftStatus(st) {
+			switch f.State {
+			case fileModified, fileConflicted:
+				cfg, _ := configByName(f.Name)
+				cfgs = append(cfgs, cfg)
+			case fileDeleted:
+				color.Yellow("⏭️  Skipping %s (deleted locally, remove it from the profile by hand)", f.Name)
+			}
+		}
+	}
+
+	var items []*saveItem
+	for _, cfg := range cfgs {
+		member, err := saveTarget(active, c.Args().First(), cfg.Name)
+		if err != nil {
+			return err
+		}
+		item, err := prepareSave(st, cfg, member, c.Bool("force"))
+		if err != nil {
+			return fmt.Errorf("refusing to save: %v", err)
+		}
+		if string(item.profile) == string(item.local) {
+			continue
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		boxInfo("Nothing To Save", "The managed files match the active profile")
+		return nil
+	}
+
+	for _, item := range items {
+		printDiff(unifiedDiff(filepath.Join(item.member, item.cfg.Name), item.cfg.Src(), item.profile, item.local, 3))
+		fmt.Println()
+	}
+
+	if !c.Bool("yes") {
+		if !isatty.IsTerminal(os.Stdin.Fd()) {
+			return fmt.Errorf("not saving without confirmation; pass --yes")
+		}
+		ok, err := confirm(bufio.NewReader(os.Stdin), fmt.Sprintf("Save %d file(s) into the profile?", len(items)), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			color.Yellow("Cancelled")
+			return nil
+		}
+	}
+
+	noteTransition(active, active)
+	var saved []string
+	for _, item := range items {
+		if err := item.write(st); err != nil {
+			return err
+		}
+		saved = append(saved, fmt.Sprintf("  • %s → %s", item.cfg.Name, item.member))
+	}
+	if err := st.save(); err != nil {
+		return err
+	}
+	boxInfo("Saved", strings.Join(saved, "\n"))
+	return nil
+}
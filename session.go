@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// envVar is a single KEY=VALUE entry of an env file.
+type envVar struct {
+	Key   string
+	Value string
+}
+
+// envKeyPattern is what a variable name may look like. Names end up in
+// export lines of the shell hooks, so anything else is rejected.
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnvFile reads a dotenv style file. Blank lines and comments are
+// skipped, an `export ` prefix is allowed and matching quotes are removed.
+func parseEnvFile(path string) ([]envVar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vars []envVar
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", filepath.Base(path), lineNo)
+		}
+		if !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: invalid variable name %q", filepath.Base(path), lineNo, key)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars = append(vars, envVar{Key: key, Value: value})
+	}
+	return vars, scanner.Err()
+}
+
+// sessionFileVars maps profile files to the variables that point tools at
+// them without replacing anything in $HOME.
+var sessionFileVars = []struct {
+	File string
+	Var  string
+}{
+	{".gitconfig", "GIT_CONFIG_GLOBAL"},
+	{"aws_config", "AWS_CONFIG_FILE"},
+	{"aws_credentials", "AWS_SHARED_CREDENTIALS_FILE"},
+	{".npmrc", "NPM_CONFIG_USERCONFIG"},
+	{"kube_config", "KUBECONFIG"},
+}
+
+// sessionEnv returns the variables that activate profile for a single
+// process tree. Files that need a directory of their own, like docker's
+// config.json, are linked into scratch, which the caller removes afterwards.
+func sessionEnv(profile, scratch string) ([]envVar, error) {
+	if err := profileExists(profile); err != nil {
+		return nil, err
+	}
+	profPath, err := profileSourceDir(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := []envVar{{Key: "DEVSWITCH_PROFILE", Value: profile}}
+	for _, fv := range sessionFileVars {
+		src := filepath.Join(profPath, fv.File)
+		if _, err := os.Stat(src); err == nil {
+			vars = append(vars, envVar{Key: fv.Var, Value: src})
+		}
+	}
+
+	dockerSrc := filepath.Join(profPath, "docker_config.json")
+	if _, err := os.Stat(dockerSrc); err == nil {
+		dockerDir := filepath.Join(scratch, "docker")
+		if err := os.MkdirAll(dockerDir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := linkOrCopy(dockerSrc, filepath.Join(dockerDir, "config.json")); err != nil {
+			return nil, err
+		}
+		vars = append(vars, envVar{Key: "DOCKER_CONFIG", Value: dockerDir})
+	}
+
+	envFile := filepath.Join(profPath, ".env")
+	if _, err := os.Stat(envFile); err == nil {
+		fileVars, err := parseEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, fileVars...)
+	}
+	return vars, nil
+}
+
+// linkOrCopy symlinks dst to src, copying where symlinks are unavailable.
+// An existing dst, such as the link of an earlier directory session, is
+// replaced rather than written through, which would truncate src.
+func linkOrCopy(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(src, dst); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	// copied quietly: this also runs inside the shell hook
+	return writeFileAtomic(dst, data, 0o600)
+}
+
+// mergeEnv overlays vars on top of base, which is in os.Environ form.
+func mergeEnv(base []string, vars []envVar) []string {
+	override := map[string]bool{}
+	for _, v := range vars {
+		override[v.Key] = true
+	}
+	env := make([]string, 0, len(base)+len(vars))
+	for _, kv := range base {
+		key, _, _ := strings.Cut(kv, "=")
+		if !override[key] {
+			env = append(env, kv)
+		}
+	}
+	for _, v := range vars {
+		env = append(env, v.Key+"="+v.Value)
+	}
+	return env
+}
+
+// runInSession runs the command built by prepare with profile active for
+// that process tree only. A failing child's exit code is passed through.
+func runInSession(profile string, prepare func(scratch string) (*exec.Cmd, error)) error {
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	scratch, err := os.MkdirTemp("", "devswitch-"+profile+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	vars, err := sessionEnv(profile, scratch)
+	if err != nil {
+		return err
+	}
+	cmd, err := prepare(scratch)
+	if err != nil {
+		return err
+	}
+	cmd.Env = mergeEnv(append(os.Environ(), cmd.Env...), vars)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The terminal delivers ^C to the child as well; let it decide.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return cli.Exit("", exitErr.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
+
+func cmdExec(c *cli.Context) error {
+	args := c.Args().Slice()
+	if len(args) < 2 {
+		return fmt.Errorf("usage: devswitch exec <profile> -- <command> [args...]")
+	}
+	profile, command := args[0], args[1:]
+	if command[0] == "--" {
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		return fmt.Errorf("command required after --")
+	}
+
+	return runInSession(profile, func(string) (*exec.Cmd, error) {
+		return exec.Command(command[0], command[1:]...), nil
+	})
+}
+
+func userShell() string {
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return sh
+	}
+	if runtime.GOOS == "windows" {
+		if cs := os.Getenv("COMSPEC"); cs != "" {
+			return cs
+		}
+		return "cmd.exe"
+	}
+	return "/bin/sh"
+}
+
+func cmdShell(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return fmt.Errorf("profile name required")
+	}
+	profile := c.Args().First()
+	profPath, err := profileSourceDir(profile)
+	if err != nil {
+		return err
+	}
+	shell := userShell()
+
+	color.Blue("🐚 Entering %s session (exit the shell to return)", profile)
+	err = runInSession(profile, func(scratch string) (*exec.Cmd, error) {
+		cmd := exec.Command(shell)
+		switch filepath.Base(shell) {
+		case "zsh":
+			// zsh reads its rc files from $ZDOTDIR
+			rc := filepath.Join(profPath, ".zshrc")
+			if _, err := os.Stat(rc); err == nil {
+				if err := linkOrCopy(rc, filepath.Join(scratch, ".zshrc")); err != nil {
+					return nil, err
+				}
+				cmd.Env = append(cmd.Env, "ZDOTDIR="+scratch)
+			}
+		case "bash":
+			rc := filepath.Join(profPath, ".bashrc")
+			if _, err := os.Stat(rc); err == nil {
+				cmd.Args = append(cmd.Args, "--rcfile", rc)
+			}
+		}
+		return cmd, nil
+	})
+	color.Blue("👋 Left %s session", profile)
+	return err
+}
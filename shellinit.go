@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// Variables the shell integration keeps in the user's shell. They are
+// exported so child shells inherit the state along with the values.
+const (
+	shellVarMarker = "__DEVSWITCH_SHELL" // set once `devswitch init` is loaded
+	shellVarList   = "__DEVSWITCH_VARS"  // names exported from the profile
+	shellVarRC     = "__DEVSWITCH_RC"    // hash of the rc file last sourced
+	shellVarGen    = "__DEVSWITCH_GEN"   // generation the shell last synced to
+)
+
+var supportedShells = []string{"bash", "zsh", "fish"}
+
+// shellRCFile is the rc file an interactive shell of the given kind reads.
+func shellRCFile(shell string) string {
+	switch shell {
+	case "zsh":
+		if zdot := os.Getenv("ZDOTDIR"); zdot != "" {
+			return filepath.Join(zdot, ".zshrc")
+		}
+		return filepath.Join(homeDir(), ".zshrc")
+	case "bash":
+		return filepath.Join(homeDir(), ".bashrc")
+	case "fish":
+		return filepath.Join(homeDir(), ".config", "fish", "config.fish")
+	}
+	return ""
+}
+
+// shellQuote quotes s as a single word for the given shell.
+func shellQuote(shell, s string) string {
+	if shell == "fish" {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellScript accumulates statements for the user's shell to evaluate.
+type shellScript struct {
+	shell string
+	b     strings.Builder
+}
+
+func (s *shellScript) export(key, value string) {
+	if s.shell == "fish" {
+		fmt.Fprintf(&s.b, "set -gx %s %s;\n", key, shellQuote(s.shell, value))
+		return
+	}
+	fmt.Fprintf(&s.b, "export %s=%s;\n", key, shellQuote(s.shell, value))
+}
+
+func (s *shellScript) unset(key string) {
+	if s.shell == "fish" {
+		fmt.Fprintf(&s.b, "set -e %s;\n", key)
+		return
+	}
+	fmt.Fprintf(&s.b, "unset %s;\n", key)
+}
+
+func (s *shellScript) source(path string) {
+	if s.shell == "fish" {
+		fmt.Fprintf(&s.b, "source %s;\n", shellQuote(s.shell, path))
+		return
+	}
+	fmt.Fprintf(&s.b, ". %s;\n", shellQuote(s.shell, path))
+}
+
+func (s *shellScript) echo(msg string) {
+	fmt.Fprintf(&s.b, "echo %s >&2;\n", shellQuote(s.shell, msg))
+}
+
+func (s *shellScript) String() string {
+	return s.b.String()
+}
+
+// activeProfileEnv returns the variables the active profile exports into
+// interactive shells.
+func activeProfileEnv(profile string) ([]envVar, error) {
+	if profile == "" {
+		return nil, nil
+	}
+	dir, err := profileSourceDir(profile)
+	if err != nil {
+		return nil, err
+	}
+	envFile := filepath.Join(dir, ".env")
+	if _, err := os.Stat(envFile); err != nil {
+		return nil, nil
+	}
+	return parseEnvFile(envFile)
+}
+
+// hookEnvScript brings the calling shell in line with the active profile:
+// stale variables of the previous profile are unset, the profile's
+// variables exported and the rc file re-sourced if its content changed.
+// With init set the rc file is only fingerprinted, since the shell is
+// reading it at that moment.
+func hookEnvScript(shell string, init bool) (string, error) {
+	s := &shellScript{shell: shell}
+
+	profile, _ := readCurrentProfile()
+	profile = strings.TrimSpace(profile)
+
+	vars, err := activeProfileEnv(profile)
+	if err != nil {
+		return "", err
+	}
+
+	// While a directory session is active its values stay in effect; the
+	// global ones become what is restored when the session ends.
+	inSession := map[string]bool{}
+	if os.Getenv(dirVarProfile) != "" {
+		for _, key := range strings.Split(os.Getenv(dirVarList), ":") {
+			inSession[key] = true
+		}
+	}
+	target := func(key string) string {
+		if inSession[key] {
+			return dirSavedVar + key
+		}
+		return key
+	}
+
+	current := map[string]bool{}
+	var names []string
+	for _, v := range vars {
+		if !current[v.Key] {
+			names = append(names, v.Key)
+		}
+		current[v.Key] = true
+	}
+	for _, old := range strings.Split(os.Getenv(shellVarList), ":") {
+		if envKeyPattern.MatchString(old) && !current[old] {
+			s.unset(target(old))
+		}
+	}
+	for _, v := range vars {
+		s.export(target(v.Key), v.Value)
+	}
+	sort.Strings(names)
+	s.export(shellVarList, strings.Join(names, ":"))
+
+	s.export(shellVarGen, strconv.Itoa(readGeneration()))
+	if profile != "" {
+		s.export(target("DEVSWITCH_PROFILE"), profile)
+	} else if _, ok := os.LookupEnv(target("DEVSWITCH_PROFILE")); ok {
+		s.unset(target("DEVSWITCH_PROFILE"))
+	}
+
+	if rc := shellRCFile(shell); rc != "" {
+		hash, err := getFileHash(rc)
+		if err == nil && hash != os.Getenv(shellVarRC) {
+			s.export(shellVarRC, hash)
+			if !init {
+				s.source(rc)
+			}
+		}
+	}
+	return s.String(), nil
+}
+
+// Wrapper functions installed by `devswitch init`. Besides reloading after
+// apply in the same shell, a prompt hook compares the generation counter
+// with the one this shell last synced to, using shell builtins only so the
+// check stays cheap.
+var posixInitScript = template.Must(template.New("posix").Parse(`# devswitch shell integration
+export {{.Marker}}={{.Shell}}
+devswitch() {
+  case "$1" in
+    reload) eval "$({{.Bin}} hook-env {{.Shell}})"; return ;;
+  esac
+  {{.Bin}} "$@"
+  local __devswitch_status=$?
+  case "$1" in
+    apply|rollback) eval "$({{.Bin}} hook-env {{.Shell}})" ;;
+  esac
+  return $__devswitch_status
+}
+__devswitch_precmd() {
+  local gen=0
+  [ -r {{.GenFile}} ] && read -r gen < {{.GenFile}}
+  [ "$gen" = "${ {{- .GenVar}}:-0}" ] && return
+{{- if .Notify}}
+  export {{.GenVar}}="$gen"
+  echo "devswitch: profile changed in another shell, run 'devswitch reload' to update this one" >&2
+{{- else}}
+  eval "$({{.Bin}} hook-env {{.Shell}})"
+{{- end}}
+}
+{{- if .DirSwitch}}
+__devswitch_chpwd() {
+  [ "$PWD" = "$__devswitch_pwd" ] && return
+  __devswitch_pwd="$PWD"
+  eval "$({{.Bin}} hook-dir {{.Shell}})"
+}
+{{- end}}
+{{- if eq .Shell "zsh"}}
+typeset -ag precmd_functions
+if (( ! ${precmd_functions[(I)__devswitch_precmd]} )); then
+  precmd_functions+=(__devswitch_precmd)
+fi
+{{- if .DirSwitch}}
+typeset -ag chpwd_functions
+if (( ! ${chpwd_functions[(I)__devswitch_chpwd]} )); then
+  chpwd_functions+=(__devswitch_chpwd)
+fi
+{{- end}}
+{{- else}}
+case ";${PROMPT_COMMAND};" in
+  *";__devswitch_precmd;"*) ;;
+  *) PROMPT_COMMAND="__devswitch_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND}" ;;
+esac
+{{- if .DirSwitch}}
+case ";${PROMPT_COMMAND};" in
+  *";__devswitch_chpwd;"*) ;;
+  *) PROMPT_COMMAND="__devswitch_chpwd;${PROMPT_COMMAND}" ;;
+esac
+{{- end}}
+{{- end}}
+eval "$({{.Bin}} hook-env {{.Shell}} --init)"
+{{- if .DirSwitch}}
+__devswitch_chpwd
+{{- end}}
+`))
+
+var fishInitScript = template.Must(template.New("fish").Parse(`# devswitch shell integration
+set -gx {{.Marker}} {{.Shell}}
+function devswitch
+    if test "$argv[1]" = reload
+        {{.Bin}} hook-env {{.Shell}} | source
+        return
+    end
+    {{.Bin}} $argv
+    set -l devswitch_status $status
+    switch "$argv[1]"
+        case apply rollback
+            {{.Bin}} hook-env {{.Shell}} | source
+    end
+    return $devswitch_status
+end
+function __devswitch_precmd --on-event fish_prompt
+    set -l gen 0
+    test -r {{.GenFile}}; and read gen < {{.GenFile}}
+    set -q {{.GenVar}}; or set -gx {{.GenVar}} 0
+    test "$gen" = "${{.GenVar}}"; and return
+{{- if .Notify}}
+    set -gx {{.GenVar}} $gen
+    echo "devswitch: profile changed in another shell, run 'devswitch reload' to update this one" >&2
+{{- else}}
+    {{.Bin}} hook-env {{.Shell}} | source
+{{- end}}
+end
+{{- if .DirSwitch}}
+function __devswitch_chpwd --on-variable PWD
+    {{.Bin}} hook-dir {{.Shell}} | source
+end
+{{- end}}
+{{.Bin}} hook-env {{.Shell}} --init | source
+{{- if .DirSwitch}}
+__devswitch_chpwd
+{{- end}}
+`))
+
+func checkShell(c *cli.Context) (string, error) {
+	shell := c.Args().First()
+	for _, s := range supportedShells {
+		if s == shell {
+			return shell, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported shell %q, expected one of: %s", shell, strings.Join(supportedShells, ", "))
+}
+
+func cmdInit(c *cli.Context) error {
+	shell, err := checkShell(c)
+	if err != nil {
+		return err
+	}
+	var notify bool
+	switch c.String("on-change") {
+	case "reload":
+	case "notify":
+		notify = true
+	default:
+		return fmt.Errorf("--on-change must be reload or notify")
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		bin = "devswitch"
+	}
+
+	data := struct {
+		Bin, Shell, Marker, GenFile, GenVar string
+		Notify, DirSwitch                   bool
+	}{
+		Bin:       shellQuote(shell, bin),
+		Shell:     shell,
+		Marker:    shellVarMarker,
+		GenFile:   shellQuote(shell, generationFile()),
+		GenVar:    shellVarGen,
+		Notify:    notify,
+		DirSwitch: c.Bool("dir-switch"),
+	}
+	tmpl := posixInitScript
+	if shell == "fish" {
+		tmpl = fishInitScript
+	}
+	return tmpl.Execute(os.Stdout, data)
+}
+
+// hookFailed reports an error of a prompt hook on stderr. The hooks' stdout
+// is eval'd by the shell, so nothing but script may be written there, and
+// the hook exits cleanly so the prompt keeps working.
+func hookFailed(err error) error {
+	color.New(color.FgYellow).Fprintf(os.Stderr, "⚠️  devswitch: %v\n", err)
+	return nil
+}
+
+func cmdHookEnv(c *cli.Context) error {
+	shell, err := checkShell(c)
+	if err != nil {
+		return hookFailed(err)
+	}
+	script, err := hookEnvScript(shell, c.Bool("init"))
+	if err != nil {
+		return hookFailed(err)
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// shellIntegrationActive reports whether devswitch runs under the wrapper
+// installed by `devswitch init`, which reloads the shell after apply.
+func shellIntegrationActive() bool {
+	return os.Getenv(shellVarMarker) != ""
+}
+
+func generationFile() string {
+	return filepath.Join(devDir(), "generation")
+}
+
+// readGeneration returns the number of profile changes made so far.
+func readGeneration() int {
+	data, err := os.ReadFile(generationFile())
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return n
+}
+
+// bumpGeneration tells already-open shells that the active profile changed;
+// their prompt hook notices the new value the next time a prompt is drawn.
+func bumpGeneration() error {
+	return os.WriteFile(generationFile(), []byte(strconv.Itoa(readGeneration()+1)+"\n"), 0o644)
+}
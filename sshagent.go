@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshAgentSettings is the `ssh.agent` section of a profile manifest.
+type sshAgentSettings struct {
+	Enabled  bool     `yaml:"enabled"`
+	Lifetime string   `yaml:"lifetime,omitempty"` // Go duration, e.g. 8h
+	Keys     []string `yaml:"keys,omitempty"`     // extra keys, relative to the profile
+}
+
+// agentKeyComment tags keys devswitch loads so they are recognisable in
+// `ssh-add -l`.
+func agentKeyComment(profile, file string) string {
+	return fmt.Sprintf("devswitch:%s:%s", profile, filepath.Base(file))
+}
+
+// profileIdentityFiles lists the private keys a profile provides: the
+// default ssh_id_* files, the identities of its ssh hosts and any extra keys
+// named in the agent settings. Only existing files are returned.
+func profileIdentityFiles(profPath string, m *profileManifest) []string {
+	var candidates []string
+	for _, name := range defaultIdentityFiles {
+		candidates = append(candidates, filepath.Join(profPath, name))
+	}
+	for _, h := range m.SSH.Hosts {
+		if id := resolveIdentityFile(profPath, h); id != "" {
+			candidates = append(candidates, id)
+		}
+	}
+	for _, k := range m.SSH.Agent.Keys {
+		candidates = append(candidates, resolveIdentityFile(profPath, sshHost{IdentityFile: k}))
+	}
+
+	seen := map[string]bool{}
+	var files []string
+	for _, f := range candidates {
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// identityPublicKey returns the public half of a private key file. The .pub
+// file is preferred so encrypted keys can be identified without a passphrase.
+func identityPublicKey(file string) (ssh.PublicKey, error) {
+	if data, err := os.ReadFile(file + ".pub"); err == nil {
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(data); err == nil {
+			return pub, nil
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && missing.PublicKey != nil {
+			return missing.PublicKey, nil
+		}
+		return nil, err
+	}
+	return signer.PublicKey(), nil
+}
+
+// connectSSHAgent dials the agent advertised by $SSH_AUTH_SOCK.
+func connectSSHAgent() (agent.ExtendedAgent, net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, fmt.Errorf("SSH_AUTH_SOCK is not set; is ssh-agent running?")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot reach ssh-agent: %v", err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// swapAgentKeys removes the keys of the previous profile from ag and loads
+// the keys of the new one. A zero lifetime keeps keys until removed.
+// Encrypted keys cannot be loaded without a passphrase and are reported in
+// skipped so the user can ssh-add them.
+func swapAgentKeys(ag agent.Agent, prevProfile, newProfile string, lifetime time.Duration) (removed, added int, skipped []string, err error) {
+	if prevProfile != "" && prevProfile != newProfile {
+		prevPath, errDir := profileSourceDir(prevProfile)
+		m, errManifest := loadResolvedManifest(prevProfile)
+		if errDir == nil && errManifest == nil {
+			for _, f := range profileIdentityFiles(prevPath, m) {
+				pub, err := identityPublicKey(f)
+				if err != nil {
+					continue
+				}
+				// keys that were never loaded are fine to miss
+				if err := ag.Remove(pub); err == nil {
+					removed++
+				}
+			}
+		}
+	}
+
+	newPath, err := profileSourceDir(newProfile)
+	if err != nil {
+		return removed, 0, nil, err
+	}
+	m, err := loadResolvedManifest(newProfile)
+	if err != nil {
+		return removed, 0, nil, err
+	}
+	for _, f := range profileIdentityFiles(newPath, m) {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return removed, added, skipped, err
+		}
+		key, err := ssh.ParseRawPrivateKey(data)
+		if err != nil {
+			var missing *ssh.PassphraseMissingError
+			if errors.As(err, &missing) {
+				skipped = append(skipped, f)
+				continue
+			}
+			return removed, added, skipped, fmt.Errorf("failed to parse %s: %v", f, err)
+		}
+		if err := ag.Add(agent.AddedKey{
+			PrivateKey:   key,
+			Comment:      agentKeyComment(newProfile, f),
+			LifetimeSecs: uint32(lifetime / time.Second),
+		}); err != nil {
+			return removed, added, skipped, fmt.Errorf("ssh-agent refused %s: %v", filepath.Base(f), err)
+		}
+		added++
+	}
+	return removed, added, skipped, nil
+}
+
+// applySSHAgent swaps agent keys as part of apply. Agent problems are only
+// reported, since the profile files have already been switched.
+func applySSHAgent(prevProfile, newProfile string, lifetime time.Duration) {
+	ag, conn, err := connectSSHAgent()
+	if err != nil {
+		color.Yellow("⚠️  Skipping ssh-agent: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	removed, added, skipped, err := swapAgentKeys(ag, prevProfile, newProfile, lifetime)
+	if err != nil {
+		color.Yellow("⚠️  ssh-agent update incomplete: %v", err)
+	}
+	for _, f := range skipped {
+		color.Yellow("⚠️  %s is passphrase protected, run: ssh-add %s", filepath.Base(f), f)
+	}
+	color.Blue("🔑 ssh-agent: removed %d, added %d keys", removed, added)
+}
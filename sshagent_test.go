@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeTestProfile creates a profile with a fresh ssh_id_ed25519 key.
+func writeTestProfile(t *testing.T, name string) {
+	t.Helper()
+	profPath := filepath.Join(profilesDir(), name)
+	if err := os.MkdirAll(profPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	priv, pub, err := generateSSHKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(profPath, "ssh_id_ed25519"), priv, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(profPath, "ssh_id_ed25519.pub"), pub, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// agentComments lists the comments of the keys held by ag.
+func agentComments(t *testing.T, ag agent.Agent) []string {
+	t.Helper()
+	keys, err := ag.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var comments []string
+	for _, k := range keys {
+		comments = append(comments, k.Comment)
+	}
+	sort.Strings(comments)
+	return comments
+}
+
+func assertComments(t *testing.T, ag agent.Agent, want ...string) {
+	t.Helper()
+	got := agentComments(t, ag)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("agent holds %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("agent holds %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSwapAgentKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	writeTestProfile(t, "work")
+	writeTestProfile(t, "personal")
+
+	ag := agent.NewKeyring()
+	// a key the user loaded by hand must survive every swap
+	_, own, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ag.Add(agent.AddedKey{PrivateKey: own, Comment: "own"}); err != nil {
+		t.Fatal(err)
+	}
+	workKey := agentKeyComment("work", "ssh_id_ed25519")
+	personalKey := agentKeyComment("personal", "ssh_id_ed25519")
+
+	// add: the first apply only loads keys
+	removed, added, skipped, err := swapAgentKeys(ag, "", "work", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 0 || added != 1 || len(skipped) != 0 {
+		t.Fatalf("first apply: removed %d, added %d, skipped %v", removed, added, skipped)
+	}
+	assertComments(t, ag, "own", workKey)
+
+	// remove: switching drops the previous profile's key
+	removed, added, _, err = swapAgentKeys(ag, "work", "personal", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || added != 1 {
+		t.Fatalf("switch: removed %d, added %d", removed, added)
+	}
+	assertComments(t, ag, "own", personalKey)
+
+	// restore: switching back loads the first profile's key again
+	removed, added, _, err = swapAgentKeys(ag, "personal", "work", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || added != 1 {
+		t.Fatalf("switch back: removed %d, added %d", removed, added)
+	}
+	assertComments(t, ag, "own", workKey)
+}
+
+func TestSwapAgentKeysSkipsEncryptedKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	profPath := filepath.Join(profilesDir(), "locked")
+	if err := os.MkdirAll(profPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "locked", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(profPath, "ssh_id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ag := agent.NewKeyring()
+	_, added, skipped, err := swapAgentKeys(ag, "", "locked", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 || len(skipped) != 1 || skipped[0] != keyFile {
+		t.Fatalf("added %d, skipped %v, want %s skipped", added, skipped, keyFile)
+	}
+	assertComments(t, ag)
+}
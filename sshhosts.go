@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/fatih/color"
+)
+
+// sshManifest is the `ssh` section of a profile manifest.
+type sshManifest struct {
+	Hosts []sshHost        `yaml:"hosts,omitempty"`
+	Agent sshAgentSettings `yaml:"agent,omitempty"`
+}
+
+// sshHost declares a Host alias that uses one of the profile's identities,
+// e.g. github.com-work for github.com.
+type sshHost struct {
+	Alias        string            `yaml:"alias"`
+	HostName     string            `yaml:"hostname"`
+	User         string            `yaml:"user,omitempty"`
+	Port         int               `yaml:"port,omitempty"`
+	IdentityFile string            `yaml:"identity_file,omitempty"`
+	Options      map[string]string `yaml:"options,omitempty"`
+}
+
+// validate rejects host entries that would not render as a single Host
+// block: names with whitespace, and values with line breaks, could add
+// directives or whole Host blocks to the managed file.
+func (h sshHost) validate() error {
+	if h.Alias == "" || h.HostName == "" {
+		return fmt.Errorf("ssh host entries need both alias and hostname")
+	}
+	for _, f := range []struct{ name, value string }{{"alias", h.Alias}, {"hostname", h.HostName}, {"user", h.User}} {
+		if strings.IndexFunc(f.value, unicode.IsSpace) >= 0 || strings.ContainsAny(f.value, `"#`) {
+			return fmt.Errorf("ssh host %q: %s %q may not contain spaces, quotes or #", h.Alias, f.name, f.value)
+		}
+	}
+	if strings.IndexFunc(h.IdentityFile, unicode.IsControl) >= 0 || strings.Contains(h.IdentityFile, `"`) {
+		return fmt.Errorf("ssh host %q: identity_file may not contain quotes or control characters", h.Alias)
+	}
+	for k, v := range h.Options {
+		if k == "" || strings.IndexFunc(k, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+			return fmt.Errorf("ssh host %q: option name %q must be letters and digits only", h.Alias, k)
+		}
+		if strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			return fmt.Errorf("ssh host %q: option %s may not contain line breaks or control characters", h.Alias, k)
+		}
+	}
+	return nil
+}
+
+// sshIncludeLine is the directive added to ~/.ssh/config so the managed
+// host file is picked up. Relative Include paths are resolved against ~/.ssh.
+const sshIncludeLine = "Include config.d/devswitch"
+
+func sshHostsFile() string {
+	return filepath.Join(homeDir(), ".ssh", "config.d", "devswitch")
+}
+
+// defaultIdentityFiles are tried, in order, when a host does not name an
+// identity_file explicitly.
+var defaultIdentityFiles = []string{"ssh_id_ed25519", "ssh_id_rsa"}
+
+// resolveIdentityFile returns the absolute path of the key a host in the
+// profile at profPath should use. Relative paths point into the profile.
+func resolveIdentityFile(profPath string, h sshHost) string {
+	id := h.IdentityFile
+	if id == "" {
+		for _, name := range defaultIdentityFiles {
+			candidate := filepath.Join(profPath, name)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate
+			}
+		}
+		return ""
+	}
+	if strings.HasPrefix(id, "~/") {
+		return filepath.Join(homeDir(), id[2:])
+	}
+	if filepath.IsAbs(id) {
+		return id
+	}
+	return filepath.Join(profPath, id)
+}
+
+// writeSSHHostBlock renders a single Host block.
+func writeSSHHostBlock(b *strings.Builder, pattern string, h sshHost, identity string) {
+	fmt.Fprintf(b, "Host %s\n", pattern)
+	fmt.Fprintf(b, "    HostName %s\n", h.HostName)
+	if h.User != "" {
+		fmt.Fprintf(b, "    User %s\n", h.User)
+	}
+	if h.Port != 0 {
+		fmt.Fprintf(b, "    Port %d\n", h.Port)
+	}
+	if identity != "" {
+		fmt.Fprintf(b, "    IdentityFile \"%s\"\n", identity)
+		b.WriteString("    IdentitiesOnly yes\n")
+	}
+	keys := make([]string, 0, len(h.Options))
+	for k := range h.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "    %s %s\n", k, h.Options[k])
+	}
+	b.WriteString("\n")
+}
+
+// renderSSHHosts builds the managed host file. Aliases of every profile are
+// always present so identities coexist; the active profile, or each member
+// of a composed one, additionally claims the bare host names, which makes
+// its keys the default.
+func renderSSHHosts(active string) (string, int, error) {
+	names, err := profileNames()
+	if err != nil {
+		return "", 0, err
+	}
+
+	var b strings.Builder
+	b.WriteString("# Managed by devswitch. Changes are overwritten on every apply.\n")
+	if active != "" {
+		fmt.Fprintf(&b, "# Active profile: %s\n", active)
+	}
+	b.WriteString("\n")
+
+	activeSet := map[string]bool{}
+	for _, p := range splitProfileSpec(active) {
+		activeSet[p] = true
+	}
+	count := 0
+	var defaults strings.Builder
+	claimed := map[string]bool{}
+	for _, name := range names {
+		profPath := filepath.Join(profilesDir(), name)
+		m, err := loadManifest(profPath)
+		if err != nil {
+			return "", 0, err
+		}
+		for _, h := range m.SSH.Hosts {
+			if err := h.validate(); err != nil {
+				return "", 0, fmt.Errorf("profile %s: %v", name, err)
+			}
+			identity := resolveIdentityFile(profPath, h)
+			writeSSHHostBlock(&b, h.Alias, h, identity)
+			count++
+
+			if activeSet[name] && h.Alias != h.HostName && !claimed[h.HostName] {
+				claimed[h.HostName] = true
+				writeSSHHostBlock(&defaults, h.HostName, h, identity)
+			}
+		}
+	}
+	if defaults.Len() > 0 {
+		fmt.Fprintf(&b, "# Defaults from %s\n", active)
+		b.WriteString(defaults.String())
+	}
+	return b.String(), count, nil
+}
+
+// secureSSHIdentities makes the host identities of the applied profiles
+// private, since ssh ignores keys that others can read. Only keys inside a
+// profile are changed; keys elsewhere belong to the user and get a warning.
+func secureSSHIdentities(spec string) error {
+	for _, name := range splitProfileSpec(spec) {
+		profPath := filepath.Join(profilesDir(), name)
+		m, err := loadManifest(profPath)
+		if err != nil {
+			return err
+		}
+		for _, h := range m.SSH.Hosts {
+			identity := resolveIdentityFile(profPath, h)
+			if identity == "" {
+				continue
+			}
+			info, err := os.Stat(identity)
+			if err != nil || info.Mode().Perm()&0o077 == 0 {
+				continue
+			}
+			if !isWithin(profPath, identity) {
+				color.Yellow("⚠️  ssh ignores %s while others can read it; run chmod 600 %s", identity, identity)
+				continue
+			}
+			if err := os.Chmod(identity, 0o600); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureSSHInclude makes sure ~/.ssh/config includes the managed host file.
+// The directive is placed first so it is not swallowed by a Host block.
+func ensureSSHInclude() error {
+	cfgPath := filepath.Join(homeDir(), ".ssh", "config")
+	data, err := os.ReadFile(cfgPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.EqualFold(strings.Join(strings.Fields(line), " "), sshIncludeLine) {
+			return nil
+		}
+	}
+	content := sshIncludeLine + "\n"
+	if len(data) > 0 {
+		content += "\n" + string(data)
+	}
+	if err := os.MkdirAll(filepath.Dir(cfgPath), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(cfgPath, []byte(content), 0o600)
+}
+
+// syncSSHHosts regenerates the managed host file for the active profile.
+// Nothing under ~/.ssh is touched unless some profile declares hosts.
+func syncSSHHosts(active string) (int, error) {
+	content, count, err := renderSSHHosts(active)
+	if err != nil {
+		return 0, err
+	}
+	hostsFile := sshHostsFile()
+	if count == 0 {
+		if _, err := os.Stat(hostsFile); os.IsNotExist(err) {
+			return 0, nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(hostsFile), 0o700); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(hostsFile, []byte(content), 0o600); err != nil {
+		return 0, err
+	}
+	if err := ensureSSHInclude(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// appliedState records what the last apply wrote, so later edits to the
+// targets can be told apart from the profile's content.
+type appliedState struct {
+	Profile string                 `json:"profile"`
+	Time    time.Time              `json:"time"`
+	Files   map[string]appliedFile `json:"files"` // config name ->
+}
+
+// appliedFile is one file written by apply. Hash is the sha256 of what was
+// written. Base is the hash of the profile's content at that time, which
+// differs from Hash when local edits were merged in; it is kept under
+// applied/base as the common ancestor of the next merge. Conflicted marks a
+// merge that left conflict markers in the file.
+type appliedFile struct {
+	Path       string `json:"path"`
+	Hash       string `json:"hash"`
+	Base       string `json:"base,omitempty"`
+	Conflicted bool   `json:"conflicted,omitempty"`
+}
+
+// profileHash is the hash of the profile content behind the file.
+func (f appliedFile) profileHash() string {
+	if f.Base != "" {
+		return f.Base
+	}
+	return f.Hash
+}
+
+// Per-file states reported by status.
+const (
+	fileInSync     = "in sync"
+	fileModified   = "modified locally"
+	fileConflicted = "conflicted"
+	fileDeleted    = "deleted"
+	fileUnmanaged  = "never managed"
+)
+
+func appliedDir() string {
+	return filepath.Join(devDir(), "applied")
+}
+
+func appliedStatePath() string {
+	return filepath.Join(appliedDir(), "manifest.json")
+}
+
+// basePath is where the profile content last applied to name is kept.
+func (st *appliedState) basePath(name string) string {
+	return filepath.Join(appliedDir(), "base", name)
+}
+
+// loadAppliedState returns the recorded state, or nil when nothing was
+// applied since the last rollback.
+func loadAppliedState() (*appliedState, error) {
+	data, err := os.ReadFile(appliedStatePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	st := &appliedState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", appliedStatePath(), err)
+	}
+	if st.Files == nil {
+		st.Files = map[string]appliedFile{}
+	}
+	return st, nil
+}
+
+func (st *appliedState) save() error {
+	if err := os.MkdirAll(appliedDir(), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(appliedStatePath(), data, 0o600); err != nil {
+		return err
+	}
+	// drop bases of files no longer applied
+	entries, _ := os.ReadDir(filepath.Join(appliedDir(), "base"))
+	for _, e := range entries {
+		if _, ok := st.Files[e.Name()]; !ok {
+			os.Remove(filepath.Join(appliedDir(), "base", e.Name()))
+		}
+	}
+	return nil
+}
+
+func clearAppliedState() error {
+	if err := os.RemoveAll(appliedDir()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// newAppliedState starts the record of an apply of profile. A partial
+// apply (--only) of the active profile keeps what it does not rewrite.
+func newAppliedState(profile string, partial bool) *appliedState {
+	if partial {
+		if prev, err := loadAppliedState(); err == nil && prev != nil && prev.Profile == profile {
+			prev.Time = time.Now()
+			return prev
+		}
+	}
+	return &appliedState{Profile: profile, Time: time.Now(), Files: map[string]appliedFile{}}
+}
+
+// record notes that apply wrote the profile's config file name to path.
+func (st *appliedState) record(name, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return st.recordMerged(name, path, data)
+}
+
+// recordMerged notes that path holds a merge of local edits and base, the
+// profile's content for name.
+func (st *appliedState) recordMerged(name, path string, base []byte) error {
+	hash, err := contentHash(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(appliedDir(), "base"), 0o700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(st.basePath(name), base, 0o600); err != nil {
+		return err
+	}
+	f := appliedFile{Path: path, Hash: hash}
+	if b := hashBytes(base); b != hash {
+		f.Base = b
+	}
+	st.Files[name] = f
+	return nil
+}
+
+// recordProfile notes that path is expected to hold content, the profile's
+// version of name, whatever it holds now.
+func (st *appliedState) recordProfile(name, path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Join(appliedDir(), "base"), 0o700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(st.basePath(name), content, 0o600); err != nil {
+		return err
+	}
+	st.Files[name] = appliedFile{Path: path, Hash: hashBytes(content)}
+	return nil
+}
+
+// fileStatus is the state of one config file relative to the last apply.
+type fileStatus struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	State string `json:"state"`
+}
+
+// driftStatus compares every config file with what the last apply wrote.
+func driftStatus(st *appliedState) []fileStatus {
+	var out []fileStatus
+	for _, cfg := range detectConfigFiles() {
+		current, err := contentHash(cfg.Src())
+		exists := err == nil
+		fst := fileStatus{Name: cfg.Name, Path: cfg.Src()}
+		rec, managed := st.Files[cfg.Name]
+		switch {
+		case !managed && !exists:
+			continue
+		case !managed:
+			fst.State = fileUnmanaged
+		case !exists:
+			fst.State = fileDeleted
+		case rec.Conflicted && hasConflictMarkers(cfg.Src()):
+			fst.State = fileConflicted
+		case current != rec.Hash:
+			fst.State = fileModified
+		default:
+			fst.State = fileInSync
+		}
+		out = append(out, fst)
+	}
+	return out
+}
+
+func stateSymbol(state string) string {
+	switch state {
+	case fileInSync:
+		return "✓"
+	case fileModified:
+		return "✎"
+	case fileConflicted:
+		return "!"
+	case fileDeleted:
+		return "✗"
+	}
+	return "·"
+}
+
+func cmdStatus(c *cli.Context) error {
+	profile, err := readCurrentProfile()
+	if err != nil {
+		boxInfo("No Active Profile", "Use 'devswitch apply <name>' to activate a profile")
+		return nil
+	}
+	st, err := loadAppliedState()
+	if err != nil {
+		return err
+	}
+	if st == nil || st.Profile != profile {
+		boxInfo("Status Unknown", fmt.Sprintf("%s is active but no record of its apply exists\n\nRe-apply it to start tracking changes", profile))
+		return nil
+	}
+
+	files := driftStatus(st)
+	drifted := 0
+	for _, f := range files {
+		if f.State == fileModified || f.State == fileConflicted || f.State == fileDeleted {
+			drifted++
+		}
+	}
+
+	if c.Bool("json") {
+		out := struct {
+			Profile string       `json:"profile"`
+			Applied time.Time    `json:"applied"`
+			Files   []fileStatus `json:"files"`
+		}{profile, st.Time, files}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s (applied %s)\n", profile, st.Time.Local().Format("2006-01-02 15:04:05"))
+		for _, f := range files {
+			if f.State == fileUnmanaged && !c.Bool("all") {
+				continue
+			}
+			fmt.Fprintf(&b, "\n  %s %-20s %s", stateSymbol(f.State), f.Name, f.State)
+		}
+		if drifted > 0 {
+			fmt.Fprintf(&b, "\n\n%d file(s) changed since apply", drifted)
+		} else {
+			b.WriteString("\n\nEverything matches the last apply")
+		}
+		boxInfo("Status", b.String())
+	}
+
+	if drifted > 0 && c.Bool("exit-code") {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
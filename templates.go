@@ -0,0 +1,323 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// builtinTemplates are shipped in the binary. Each directory under
+// templates/ is one template and is copied into new profiles as is.
+//
+//go:embed all:templates
+var builtinTemplates embed.FS
+
+// profileTemplate is a directory tree that new profiles start from. It may
+// contain config files, *.tmpl files, a devswitch.yaml manifest with vars
+// and prompts, and hooks.
+type profileTemplate struct {
+	Name    string
+	BuiltIn bool
+	fsys    fs.FS
+}
+
+// templateFile is one file of a template; Path uses forward slashes.
+type templateFile struct {
+	Path    string
+	Content []byte
+	Perm    os.FileMode
+}
+
+func templatesDir() string {
+	return filepath.Join(devDir(), "templates")
+}
+
+// listTemplates returns user templates and the built-in ones they do not
+// shadow, sorted by name.
+func listTemplates() ([]*profileTemplate, error) {
+	byName := map[string]*profileTemplate{}
+	entries, err := fs.ReadDir(builtinTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			sub, err := fs.Sub(builtinTemplates, path.Join("templates", e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			byName[e.Name()] = &profileTemplate{Name: e.Name(), BuiltIn: true, fsys: sub}
+		}
+	}
+
+	userEntries, err := os.ReadDir(templatesDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range userEntries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			byName[e.Name()] = &profileTemplate{Name: e.Name(), fsys: os.DirFS(filepath.Join(templatesDir(), e.Name()))}
+		}
+	}
+
+	var templates []*profileTemplate
+	for _, name := range sortedKeys(byName) {
+		templates = append(templates, byName[name])
+	}
+	return templates, nil
+}
+
+func templateNames() []string {
+	templates, _ := listTemplates()
+	var names []string
+	for _, t := range templates {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// findTemplate looks a template up by name; user templates win over
+// built-in ones of the same name.
+func findTemplate(name string) (*profileTemplate, error) {
+	templates, err := listTemplates()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range templates {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("template '%s' not found. Available templates: %s", name, strings.Join(templateNames(), ", "))
+}
+
+// files returns every file of the template. Embedded files carry no mode,
+// so built-in hooks are made executable by location.
+func (t *profileTemplate) files() ([]templateFile, error) {
+	var files []templateFile
+	err := fs.WalkDir(t.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(t.fsys, p)
+		if err != nil {
+			return err
+		}
+		perm := os.FileMode(0o644)
+		if t.BuiltIn {
+			if strings.HasPrefix(p, "hooks/") {
+				perm = 0o755
+			}
+		} else if info, err := d.Info(); err == nil {
+			perm = info.Mode().Perm()
+		}
+		files = append(files, templateFile{Path: p, Content: data, Perm: perm})
+		return nil
+	})
+	return files, err
+}
+
+func (t *profileTemplate) kind() string {
+	if t.BuiltIn {
+		return "built-in"
+	}
+	return "user"
+}
+
+// isTemplateSecret reports files that are never copied into a template:
+// private keys belong to one person.
+func isTemplateSecret(name string) bool {
+	return strings.HasPrefix(name, "ssh_id_") && !strings.HasSuffix(name, ".pub")
+}
+
+func cmdTemplateList(c *cli.Context) error {
+	templates, err := listTemplates()
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, t := range templates {
+		files, err := t.files()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%-16s %-9s %d files\n", t.Name, t.kind(), len(files))
+	}
+	boxInfo("Templates", strings.TrimRight(b.String(), "\n"))
+	return nil
+}
+
+func cmdTemplateShow(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return fmt.Errorf("template name required")
+	}
+	t, err := findTemplate(c.Args().First())
+	if err != nil {
+		return err
+	}
+	files, err := t.files()
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)\n", t.Name, t.kind())
+	for _, f := range files {
+		fmt.Fprintf(&b, "\n  %-24s %4d bytes  %v", f.Path, len(f.Content), f.Perm)
+	}
+	boxInfo("Template", b.String())
+
+	if c.Bool("content") {
+		for _, f := range files {
+			color.Cyan("── %s ──", f.Path)
+			fmt.Println(strings.TrimRight(string(f.Content), "\n"))
+		}
+	}
+	return nil
+}
+
+// cmdTemplateCreateFrom saves a profile as a user template. Private SSH
+// keys are left out.
+func cmdTemplateCreateFrom(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return fmt.Errorf("profile name required")
+	}
+	profile := c.Args().First()
+	name := profile
+	if c.Args().Len() > 1 {
+		name = c.Args().Get(1)
+	}
+	profPath, err := existingProfile(profile)
+	if err != nil {
+		return err
+	}
+	dst, err := userTemplateDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil && !c.Bool("force") {
+		return fmt.Errorf("template %s already exists, use --force to replace it", name)
+	}
+
+	if err := os.MkdirAll(templatesDir(), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(templatesDir(), ".new-")
+	if err != nil {
+		return err
+	}
+	count := 0
+	err = filepath.WalkDir(profPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if isTemplateSecret(d.Name()) {
+			color.Yellow("⏭️  Skipping %s (private key)", d.Name())
+			return nil
+		}
+		rel, err := filepath.Rel(profPath, p)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(tmp, filepath.Dir(rel)), 0o755); err != nil {
+			return err
+		}
+		count++
+		return copyFilePerm(p, filepath.Join(tmp, rel))
+	})
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	boxInfo("Template Created", fmt.Sprintf("%s (%d files from profile %s)", name, count, profile))
+	return nil
+}
+
+// userTemplateDir returns the directory of the user template name, making
+// sure it is a direct child of the templates directory.
+func userTemplateDir(name string) (string, error) {
+	if err := checkName("template", name); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(templatesDir(), name)
+	if filepath.Dir(dir) != templatesDir() {
+		return "", fmt.Errorf("invalid template name %q", name)
+	}
+	return dir, nil
+}
+
+// copyFilePerm copies a file keeping its permission bits, so hooks stay
+// executable.
+func copyFilePerm(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, info.Mode().Perm())
+}
+
+func cmdTemplateDelete(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return fmt.Errorf("template name required")
+	}
+	name := c.Args().First()
+	dir, err := userTemplateDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		if _, ferr := findTemplate(name); ferr == nil {
+			return fmt.Errorf("%s is a built-in template and cannot be deleted", name)
+		}
+		return fmt.Errorf("template %s does not exist", name)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	msg := "Removed user template " + name
+	if _, err := findTemplate(name); err == nil {
+		msg += "\n\nThe built-in template of the same name is used again."
+	}
+	boxInfo("Template Deleted", msg)
+	return nil
+}
+
+// writeTemplate copies a template into profPath.
+func writeTemplate(profPath string, t *profileTemplate) error {
+	files, err := t.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		dst := filepath.Join(profPath, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, f.Content, f.Perm); err != nil {
+			return fmt.Errorf("failed to write %s: %v", f.Path, err)
+		}
+		color.Green("✅ Created %s", f.Path)
+	}
+	return nil
+}
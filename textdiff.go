@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the LCS table; larger inputs are reported as
+// differing without a line diff.
+const maxDiffCells = 16 << 20
+
+// diffOp is one line of a line diff: ' ' kept, '-' only in a, '+' only in b.
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+// splitLines splits text into lines without their newlines.
+func splitLines(data []byte) []string {
+	s := string(data)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lcsTable returns t where t[i][j] is the length of the longest common
+// subsequence of a[i:] and b[j:].
+func lcsTable(a, b []string) [][]int32 {
+	t := make([][]int32, len(a)+1)
+	for i := range t {
+		t[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				t[i][j] = t[i+1][j+1] + 1
+			} else if t[i+1][j] >= t[i][j+1] {
+				t[i][j] = t[i+1][j]
+			} else {
+				t[i][j] = t[i][j+1]
+			}
+		}
+	}
+	return t
+}
+
+// diffLines computes a minimal line diff of a and b. ok is false when the
+// inputs are too large to diff.
+func diffLines(a, b []string) (ops []diffOp, ok bool) {
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return nil, false
+	}
+	t := lcsTable(a, b)
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case t[i+1][j] >= t[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops, true
+}
+
+// noNewlineMarker follows the last line of a file that does not end with a
+// newline. Lines never contain a newline, so such a line differs from the
+// same text followed by one, as in diff -u.
+const noNewlineMarker = "\n\\ No newline at end of file"
+
+// diffInputLines splits data for diffing, marking a missing final newline.
+func diffInputLines(data []byte) []string {
+	lines := splitLines(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines[len(lines)-1] += noNewlineMarker
+	}
+	return lines
+}
+
+// unifiedDiff renders the differences between a and b in unified format
+// with context lines around each change. It is empty when they are equal.
+func unifiedDiff(nameA, nameB string, a, b []byte, context int) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	ops, ok := diffLines(diffInputLines(a), diffInputLines(b))
+	if !ok {
+		return fmt.Sprintf("Files %s and %s differ\n", nameA, nameB)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+	// walk hunks: runs of changes with up to context kept lines around them
+	for start := 0; start < len(ops); {
+		first := start
+		for first < len(ops) && ops[first].Kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		from := max(first-context, start)
+		end := first
+		for end < len(ops) {
+			if ops[end].Kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].Kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end = min(end+context, run)
+				break
+			}
+			end = run
+		}
+
+		aLine, bLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.Kind != '+' {
+				aLine++
+			}
+			if op.Kind != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[from:end] {
+			if op.Kind != '+' {
+				aCount++
+			}
+			if op.Kind != '-' {
+				bCount++
+			}
+		}
+		// an empty range names the line before it
+		if aCount == 0 {
+			aLine--
+		}
+		if bCount == 0 {
+			bLine--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+		for _, op := range ops[from:end] {
+			fmt.Fprintf(&out, "%c%s\n", op.Kind, op.Line)
+		}
+		start = end
+	}
+	return out.String()
+}
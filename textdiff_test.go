@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{
+			name: "equal",
+			a:    "1\n2\n", b: "1\n2\n",
+			context: 3,
+			want:    "",
+		},
+		{
+			name: "one change with context",
+			a:    "1\n2\n3\n4\n5\n", b: "1\n2\nX\n4\n5\n",
+			context: 1,
+			want:    "@@ -2,3 +2,3 @@\n 2\n-3\n+X\n 4\n",
+		},
+		{
+			name: "distant changes get their own hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", b: "1\nB\n3\n4\n5\n6\n7\n8\nI\n10\n",
+			context: 1,
+			want:    "@@ -1,3 +1,3 @@\n 1\n-2\n+B\n 3\n@@ -8,3 +8,3 @@\n 8\n-9\n+I\n 10\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			a:    "1\n2\n3\n4\n5\n6\n", b: "1\nB\n3\nD\n5\n6\n",
+			context: 1,
+			want:    "@@ -1,5 +1,5 @@\n 1\n-2\n+B\n 3\n-4\n+D\n 5\n",
+		},
+		{
+			name: "insertion without context names the line before",
+			a:    "1\n2\n", b: "1\nX\n2\n",
+			context: 0,
+			want:    "@@ -1,0 +2,1 @@\n+X\n",
+		},
+		{
+			name: "lines added at the end",
+			a:    "1\n2\n", b: "1\n2\n3\n",
+			context: 3,
+			want:    "@@ -1,2 +1,3 @@\n 1\n 2\n+3\n",
+		},
+		{
+			name: "empty old file",
+			a:    "", b: "x\ny\n",
+			context: 3,
+			want:    "@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			name: "empty new file",
+			a:    "x\n", b: "",
+			context: 3,
+			want:    "@@ -1,1 +0,0 @@\n-x\n",
+		},
+		{
+			name: "newline added at the end",
+			a:    "a\nb", b: "a\nb\n",
+			context: 3,
+			want:    "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "newline removed with a change",
+			a:    "a\nb\n", b: "a\nc",
+			context: 3,
+			want:    "@@ -1,2 +1,2 @@\n a\n-b\n+c\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff("a", "b", []byte(tt.a), []byte(tt.b), tt.context)
+			want := tt.want
+			if want != "" {
+				want = "--- a\n+++ b\n" + want
+			}
+			if got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffTooLarge(t *testing.T) {
+	big := strings.Repeat("x\n", 4200)
+	got := unifiedDiff("a", "b", []byte(big), []byte(big+"y\n"), 3)
+	if got != "Files a and b differ\n" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v2"
+)
+
+// trustStore records which repository-local config files the user approved.
+// Approval is tied to a content hash, so any change to an allowed file
+// requires approving it again.
+type trustStore struct {
+	Allowed map[string]string `json:"allowed"` // path -> sha256 of content
+	Denied  map[string]bool   `json:"denied"`
+}
+
+type trustState int
+
+const (
+	trustUnknown trustState = iota
+	trustAllowed
+	trustModified
+	trustDenied
+)
+
+func trustPath() string {
+	return filepath.Join(devDir(), "trust.json")
+}
+
+func loadTrustStore() (*trustStore, error) {
+	ts := &trustStore{Allowed: map[string]string{}, Denied: map[string]bool{}}
+	data, err := os.ReadFile(trustPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ts, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ts); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", trustPath(), err)
+	}
+	if ts.Allowed == nil {
+		ts.Allowed = map[string]string{}
+	}
+	if ts.Denied == nil {
+		ts.Denied = map[string]bool{}
+	}
+	return ts, nil
+}
+
+func (ts *trustStore) save() error {
+	data, err := json.MarshalIndent(ts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(trustPath(), data, 0o600)
+}
+
+func contentHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+func hashBytes(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func (ts *trustStore) state(path string) (trustState, error) {
+	if ts.Denied[path] {
+		return trustDenied, nil
+	}
+	want, ok := ts.Allowed[path]
+	if !ok {
+		return trustUnknown, nil
+	}
+	got, err := contentHash(path)
+	if err != nil {
+		return trustUnknown, err
+	}
+	if got != want {
+		return trustModified, nil
+	}
+	return trustAllowed, nil
+}
+
+// checkLocalTrust explains why the local config at path may not be used, or
+// returns "" if it is allowed.
+func checkLocalTrust(path string) (string, error) {
+	ts, err := loadTrustStore()
+	if err != nil {
+		return "", err
+	}
+	state, err := ts.state(path)
+	if err != nil {
+		return "", err
+	}
+	return trustReason(path, state), nil
+}
+
+func trustReason(path string, state trustState) string {
+	switch state {
+	case trustAllowed:
+		return ""
+	case trustDenied:
+		return fmt.Sprintf("%s is denied", path)
+	case trustModified:
+		return fmt.Sprintf("%s changed since it was allowed, review it and run 'devswitch allow'", path)
+	default:
+		return fmt.Sprintf("%s is not trusted yet, review it and run 'devswitch allow'", path)
+	}
+}
+
+// requireTrusted fails unless the local config at path is allowed as is.
+func requireTrusted(path string) error {
+	reason, err := checkLocalTrust(path)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return fmt.Errorf("refusing to apply: %s", reason)
+	}
+	return nil
+}
+
+// findLocalConfig returns the local config for the given argument: a file,
+// a directory holding one, or the nearest one above the working directory.
+func findLocalConfig(arg string) (string, error) {
+	start := arg
+	if start == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		start = wd
+	}
+	start, err := filepath.Abs(start)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(start); err == nil && !info.IsDir() {
+		return start, nil
+	}
+	for d := start; ; d = filepath.Dir(d) {
+		if cfg := localConfigIn(d); cfg != "" {
+			return cfg, nil
+		}
+		if arg != "" || filepath.Dir(d) == d {
+			break
+		}
+	}
+	return "", fmt.Errorf("no %s found", localConfigNames[0])
+}
+
+func cmdAllow(c *cli.Context) error {
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	cfg, err := findLocalConfig(c.Args().First())
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(cfg)
+	if err != nil {
+		return err
+	}
+	// validate before trusting so a typo does not get approved
+	if _, err := loadLocalConfig(cfg); err != nil {
+		return err
+	}
+
+	// show exactly what is being approved before recording it
+	color.Blue("📄 %s", cfg)
+	fmt.Println(string(data))
+	if !c.Bool("yes") {
+		if !isatty.IsTerminal(os.Stdin.Fd()) {
+			return fmt.Errorf("not allowing without confirmation; pass --yes")
+		}
+		ok, err := confirm(bufio.NewReader(os.Stdin), "Allow this config?", false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			color.Yellow("Cancelled")
+			return nil
+		}
+	}
+
+	ts, err := loadTrustStore()
+	if err != nil {
+		return err
+	}
+	ts.Allowed[cfg] = fmt.Sprintf("%x", sha256.Sum256(data))
+	delete(ts.Denied, cfg)
+	if err := ts.save(); err != nil {
+		return err
+	}
+	boxInfo("Config Allowed", cfg)
+	return nil
+}
+
+func cmdDeny(c *cli.Context) error {
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	cfg, err := findLocalConfig(c.Args().First())
+	if err != nil {
+		return err
+	}
+	ts, err := loadTrustStore()
+	if err != nil {
+		return err
+	}
+	delete(ts.Allowed, cfg)
+	ts.Denied[cfg] = true
+	if err := ts.save(); err != nil {
+		return err
+	}
+	boxInfo("Config Denied", cfg)
+	return nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// undoLimit is how many applies can be undone; older snapshots are pruned.
+const undoLimit = 20
+
+var undoFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "force",
+		Usage: "Replace config files that were edited since the apply",
+	},
+	&cli.BoolFlag{
+		Name:  "list",
+		Usage: "Show the undo stack instead",
+	},
+}
+
+// undoEntry is one apply on the undo stack. Its snapshots hold the config
+// files as they were right before and right after the apply.
+type undoEntry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to"`
+}
+
+// undoStack lists applies oldest first. Entries before Position are in
+// effect and can be undone, the ones from Position on can be redone.
+type undoStack struct {
+	Entries  []undoEntry `json:"entries"`
+	Position int         `json:"position"`
+}
+
+// snapshotMeta describes a snapshot beyond the files it contains.
+type snapshotMeta struct {
+	Profile string       `json:"profile,omitempty"`
+	Origins *composition `json:"origins,omitempty"`
+	Missing []string     `json:"missing,omitempty"` // config files that did not exist
+}
+
+const (
+	snapshotMetaName   = "snapshot.json"
+	snapshotAppliedDir = ".applied" // the apply record, see status
+)
+
+func undoDir() string {
+	return filepath.Join(devDir(), "undo")
+}
+
+func undoStackPath() string {
+	return filepath.Join(undoDir(), "stack.json")
+}
+
+func loadUndoStack() (*undoStack, error) {
+	s := &undoStack{}
+	data, err := os.ReadFile(undoStackPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", undoStackPath(), err)
+	}
+	if s.Position < 0 || s.Position > len(s.Entries) {
+		s.Position = len(s.Entries)
+	}
+	return s, nil
+}
+
+func (s *undoStack) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(undoStackPath(), data, 0o600)
+}
+
+// takeSnapshot copies every config file into dir together with the active
+// profile, so the state can be restored exactly.
+func takeSnapshot(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	meta := snapshotMeta{Origins: readOrigins()}
+	meta.Profile, _ = readCurrentProfile()
+	for _, cfg := range detectConfigFiles() {
+		if _, err := os.Stat(cfg.Src()); err != nil {
+			meta.Missing = append(meta.Missing, cfg.Name)
+			continue
+		}
+		if err := copyFilePerm(cfg.Src(), filepath.Join(dir, cfg.Name)); err != nil {
+			return err
+		}
+	}
+	if err := copyTree(appliedDir(), filepath.Join(dir, snapshotAppliedDir)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, snapshotMetaName), data, 0o600)
+}
+
+// copyTree copies the regular files below src to dst, keeping their modes.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0o700)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFilePerm(p, filepath.Join(dst, rel))
+	})
+}
+
+func loadSnapshotMeta(dir string) (*snapshotMeta, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotMetaName))
+	if err != nil {
+		return nil, err
+	}
+	meta := &snapshotMeta{}
+	return meta, json.Unmarshal(data, meta)
+}
+
+// snapshotDrift lists the config files whose current state differs from
+// the snapshot in dir.
+func snapshotDrift(dir string) ([]string, error) {
+	meta, err := loadSnapshotMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+	missing := map[string]bool{}
+	for _, name := range meta.Missing {
+		missing[name] = true
+	}
+	var drift []string
+	for _, cfg := range detectConfigFiles() {
+		current, curErr := contentHash(cfg.Src())
+		if missing[cfg.Name] {
+			if curErr == nil {
+				drift = append(drift, cfg.Name)
+			}
+			continue
+		}
+		saved, err := contentHash(filepath.Join(dir, cfg.Name))
+		if err != nil {
+			// not managed when the snapshot was taken
+			continue
+		}
+		if curErr != nil || current != saved {
+			drift = append(drift, cfg.Name)
+		}
+	}
+	return drift, nil
+}
+
+// restoreSnapshot puts the config files and the active profile back to
+// the state recorded in dir.
+func restoreSnapshot(dir string) (*snapshotMeta, error) {
+	meta, err := loadSnapshotMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+	missing := map[string]bool{}
+	for _, name := range meta.Missing {
+		missing[name] = true
+	}
+	for _, cfg := range detectConfigFiles() {
+		before, _ := contentHash(cfg.Src())
+		if missing[cfg.Name] {
+			if before == "" {
+				continue
+			}
+			color.Blue("🗑️  Removing %s...", cfg.Name)
+			if err := os.Remove(cfg.Src()); err != nil {
+				return nil, err
+			}
+			noteFileChange(cfg.Name, cfg.Src(), before)
+			continue
+		}
+		src := filepath.Join(dir, cfg.Name)
+		saved, err := contentHash(src)
+		if err != nil || saved == before {
+			continue
+		}
+		color.Blue("📋 Restoring %s...", cfg.Name)
+		if err := os.MkdirAll(filepath.Dir(cfg.Src()), 0o755); err != nil {
+			return nil, err
+		}
+		if err := copyFilePerm(src, cfg.Src()); err != nil {
+			return nil, err
+		}
+		noteFileChange(cfg.Name, cfg.Src(), before)
+	}
+
+	if meta.Profile != "" {
+		if err := writeCurrentProfile(meta.Profile); err != nil {
+			return nil, err
+		}
+	} else if err := os.Remove(filepath.Join(devDir(), "current_profile.txt")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if meta.Origins != nil {
+		err = writeOrigins(meta.Origins)
+	} else {
+		err = clearOrigins()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syncSSHHosts(meta.Profile); err != nil {
+		color.Yellow("⚠️  Could not update ssh hosts: %v", err)
+	}
+	if err := clearAppliedState(); err != nil {
+		return nil, err
+	}
+	if err := copyTree(filepath.Join(dir, snapshotAppliedDir), appliedDir()); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := bumpGeneration(); err != nil {
+		color.Yellow("⚠️  Could not notify open shells: %v", err)
+	}
+	return meta, nil
+}
+
+// beginUndo snapshots the state before an apply changes anything. The
+// snapshot only joins the stack once recordUndo is called.
+func beginUndo() (string, error) {
+	if err := os.MkdirAll(undoDir(), 0o700); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(undoDir(), ".apply-")
+	if err != nil {
+		return "", err
+	}
+	if err := takeSnapshot(filepath.Join(tmp, "pre")); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// recordUndo snapshots the state after a successful apply and pushes it,
+// dropping anything that could have been redone.
+func recordUndo(tmp, from, to string) error {
+	if err := takeSnapshot(filepath.Join(tmp, "post")); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	s, err := loadUndoStack()
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	entry := undoEntry{ID: time.Now().Format("20060102-150405.000000000"), Time: time.Now(), From: from, To: to}
+	if err := os.Rename(tmp, filepath.Join(undoDir(), entry.ID)); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	s.Entries = append(s.Entries[:s.Position], entry)
+	if len(s.Entries) > undoLimit {
+		s.Entries = s.Entries[len(s.Entries)-undoLimit:]
+	}
+	s.Position = len(s.Entries)
+	if err := s.save(); err != nil {
+		return err
+	}
+	return pruneUndo(s)
+}
+
+// pruneUndo removes snapshot directories the stack no longer refers to,
+// including those of applies that failed halfway.
+func pruneUndo(s *undoStack) error {
+	keep := map[string]bool{}
+	for _, e := range s.Entries {
+		keep[e.ID] = true
+	}
+	entries, err := os.ReadDir(undoDir())
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() && !keep[e.Name()] {
+			os.RemoveAll(filepath.Join(undoDir(), e.Name()))
+		}
+	}
+	return nil
+}
+
+func profileLabel(name string) string {
+	if name == "" {
+		return "(no profile)"
+	}
+	return name
+}
+
+// stepUndo moves one entry back (undo) or forward (redo). The files must
+// still be as the step left them, otherwise local edits would be lost
+// without --force.
+func stepUndo(c *cli.Context, redo bool) error {
+	lock, err := acquireStateLock(stateLockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	s, err := loadUndoStack()
+	if err != nil {
+		return err
+	}
+	var entry undoEntry
+	var expect, target string
+	if redo {
+		if s.Position >= len(s.Entries) {
+			boxInfo("Nothing To Redo", "No undone apply to redo")
+			return nil
+		}
+		entry = s.Entries[s.Position]
+		expect, target = "pre", "post"
+	} else {
+		if s.Position == 0 {
+			boxInfo("Nothing To Undo", "No recorded apply to undo")
+			return nil
+		}
+		entry = s.Entries[s.Position-1]
+		expect, target = "post", "pre"
+	}
+	dir := filepath.Join(undoDir(), entry.ID)
+
+	drift, err := snapshotDrift(filepath.Join(dir, expect))
+	if err != nil {
+		return fmt.Errorf("snapshot of %s is unreadable: %v", entry.ID, err)
+	}
+	if len(drift) > 0 && !c.Bool("force") {
+		return fmt.Errorf("%s changed since then; run 'devswitch backup' and retry with --force to replace them", strings.Join(drift, ", "))
+	}
+
+	// undo goes from the apply's target back to its origin, redo forward
+	from, to, title := entry.To, entry.From, "Undone"
+	if redo {
+		from, to, title = entry.From, entry.To, "Redone"
+	}
+	noteTransition(from, to)
+	if _, err := restoreSnapshot(filepath.Join(dir, target)); err != nil {
+		return err
+	}
+	if redo {
+		s.Position++
+	} else {
+		s.Position--
+	}
+	if err := s.save(); err != nil {
+		return err
+	}
+
+	boxInfo(title, fmt.Sprintf("apply of %s at %s\n\n%s → %s", entry.To, entry.Time.Local().Format("2006-01-02 15:04:05"), profileLabel(from), profileLabel(to)))
+	return nil
+}
+
+func listUndo() error {
+	s, err := loadUndoStack()
+	if err != nil {
+		return err
+	}
+	if len(s.Entries) == 0 {
+		boxInfo("Undo Stack", "No recorded applies")
+		return nil
+	}
+	var b strings.Builder
+	for i := len(s.Entries) - 1; i >= 0; i-- {
+		e := s.Entries[i]
+		marker := "  "
+		if i == s.Position-1 {
+			marker = "→ "
+		}
+		state := ""
+		if i >= s.Position {
+			state = " (undone)"
+		}
+		fmt.Fprintf(&b, "%s%s  %s → %s%s\n", marker, e.Time.Local().Format("2006-01-02 15:04:05"), profileLabel(e.From), e.To, state)
+	}
+	boxInfo("Undo Stack", strings.TrimRight(b.String(), "\n"))
+	return nil
+}
+
+// cmdUndo and cmdRedo log their steps; listing the stack is not logged.
+func cmdUndo(c *cli.Context) error {
+	if c.Bool("list") {
+		return listUndo()
+	}
+	return logged(func(c *cli.Context) error { return stepUndo(c, false) })(c)
+}
+
+func cmdRedo(c *cli.Context) error {
+	if c.Bool("list") {
+		return listUndo()
+	}
+	return logged(func(c *cli.Context) error { return stepUndo(c, true) })(c)
+}
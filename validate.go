@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// fileCheck is the result of checking one profile file.
+type fileCheck struct {
+	Name    string
+	Kind    string // format the file was checked as, empty if unchecked
+	Err     error
+	Warning string // a problem the file's tool tolerates
+	Skipped string // why the check could not run
+}
+
+// fileKind decides how a profile file is checked from its name.
+func fileKind(name string) string {
+	base := filepath.Base(name)
+	switch {
+	case base == manifestName:
+		return "manifest"
+	case base == "settings.json":
+		return "jsonc"
+	case strings.HasSuffix(base, ".json"):
+		return "json"
+	case strings.HasSuffix(base, ".yaml"), strings.HasSuffix(base, ".yml"), base == "kube_config":
+		return "yaml"
+	case base == ".gitconfig":
+		return "gitconfig"
+	case base == ".npmrc":
+		return "npmrc"
+	case base == "aws_config", base == "aws_credentials":
+		return "ini"
+	case base == ".env", strings.HasPrefix(base, ".env."):
+		return "dotenv"
+	case base == ".zshrc":
+		return "zsh"
+	case base == ".bashrc", base == ".bash_profile":
+		return "bash"
+	case base == ".profile":
+		return "sh"
+	}
+	return ""
+}
+
+// lineOf returns the 1-based line of a byte offset.
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func checkJSON(data []byte) error {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		return fmt.Errorf("line %d: %v", lineOf(data, syntax.Offset), err)
+	}
+	return err
+}
+
+func checkManifest(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var m profileManifest
+	if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	for _, h := range m.SSH.Hosts {
+		if err := h.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	iniSection = regexp.MustCompile(`^\[[^\[\]]+\]$`)
+	iniKey     = regexp.MustCompile(`^[^=\s][^=]*?\s*=`)
+	envLine    = regexp.MustCompile(`^(export\s+)?[A-Za-z_][A-Za-z0-9_]*\s*=`)
+)
+
+// checkINI accepts section headers, key = value lines and comments.
+func checkINI(data []byte) error {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "["):
+			if !iniSection.MatchString(line) {
+				return fmt.Errorf("line %d: malformed section header %q", n, line)
+			}
+		case !iniKey.MatchString(line):
+			return fmt.Errorf("line %d: expected key = value, got %q", n, line)
+		}
+	}
+	return sc.Err()
+}
+
+// checkDotenv accepts KEY=value lines with balanced quotes.
+func checkDotenv(data []byte) error {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !envLine.MatchString(line) {
+			return fmt.Errorf("line %d: expected KEY=value, got %q", n, line)
+		}
+		value := strings.TrimSpace(line[strings.Index(line, "=")+1:])
+		for _, q := range []string{`"`, `'`} {
+			if strings.HasPrefix(value, q) && (len(value) < 2 || !strings.Contains(value[1:], q)) {
+				return fmt.Errorf("line %d: unterminated %s quote", n, q)
+			}
+		}
+	}
+	return sc.Err()
+}
+
+// checkWithTool runs a syntax check on a copy of the file named file; its
+// errors are reported against file. ok is false when the tool is not
+// installed.
+func checkWithTool(data []byte, file, name string, args ...string) (ok bool, err error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return false, nil
+	}
+	tmp, err := os.CreateTemp("", "devswitch-check-")
+	if err != nil {
+		return true, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return true, err
+	}
+	tmp.Close()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, append(args, tmp.Name())...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(strings.ReplaceAll(stderr.String(), tmp.Name(), file))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return true, errors.New(msg)
+	}
+	return true, nil
+}
+
+// checkProfileFile checks the syntax of a profile file by its kind. Shell
+// files and gitconfig are checked with the installed shell and git.
+func checkProfileFile(name string, data []byte) fileCheck {
+	fc := fileCheck{Name: name, Kind: fileKind(name)}
+	if strings.HasSuffix(name, templateSuffix) {
+		// rendered per machine; the rendered file is checked on apply
+		fc.Kind = ""
+		return fc
+	}
+	switch fc.Kind {
+	case "manifest":
+		fc.Err = checkManifest(data)
+	case "json":
+		fc.Err = checkJSON(data)
+	case "jsonc":
+		fc.Err = checkJSON(stripJSONC(data))
+	case "yaml":
+		var v interface{}
+		fc.Err = yaml.Unmarshal(data, &v)
+	case "ini":
+		fc.Err = checkINI(data)
+	case "npmrc":
+		// npm skips lines it cannot parse and reads a bare key as true
+		if err := checkINI(data); err != nil {
+			fc.Warning = err.Error()
+		}
+	case "dotenv":
+		fc.Err = checkDotenv(data)
+	case "gitconfig":
+		ok, err := checkWithTool(data, name, "git", "config", "--list", "--file")
+		if !ok {
+			// no git; at least the INI structure
+			fc.Err = checkINI(data)
+		} else {
+			fc.Err = err
+		}
+	case "zsh", "bash", "sh":
+		ok, err := checkWithTool(data, name, fc.Kind, "-n")
+		if !ok {
+			fc.Skipped = fc.Kind + " is not installed"
+		}
+		fc.Err = err
+	}
+	return fc
+}
+
+// validateDir checks the files apply would write from dir, which is the
+// profile itself or its resolved build, and the manifests of its layers.
+func validateDir(profile, dir string, allowed map[string]bool) ([]fileCheck, error) {
+	var checks []fileCheck
+	for _, cfg := range detectConfigFiles() {
+		if allowed != nil && !allowed[cfg.Name] {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, cfg.Name))
+		if err != nil {
+			continue
+		}
+		checks = append(checks, checkProfileFile(cfg.Name, data))
+	}
+	for _, member := range splitProfileSpec(profile) {
+		layers, err := profileLayers(member)
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			data, err := os.ReadFile(filepath.Join(profilesDir(), layer, manifestName))
+			if err != nil {
+				continue
+			}
+			fc := checkProfileFile(manifestName, data)
+			if len(layers) > 1 || isComposite(profile) {
+				fc.Name = layer + "/" + manifestName
+			}
+			checks = append(checks, fc)
+		}
+	}
+	return checks, nil
+}
+
+// failedChecks returns the checks that found an error.
+func failedChecks(checks []fileCheck) []fileCheck {
+	var failed []fileCheck
+	for _, fc := range checks {
+		if fc.Err != nil {
+			failed = append(failed, fc)
+		}
+	}
+	return failed
+}
+
+// validateBeforeApply stops apply when a file it would write is invalid.
+func validateBeforeApply(profile, srcDir string, allowed map[string]bool) error {
+	checks, err := validateDir(profile, srcDir, allowed)
+	if err != nil {
+		return err
+	}
+	failed := failedChecks(checks)
+	if len(failed) == 0 {
+		return nil
+	}
+	var names []string
+	for _, fc := range failed {
+		color.Red("❌ %s: %v", fc.Name, fc.Err)
+		names = append(names, fc.Name)
+	}
+	return fmt.Errorf("profile %s has invalid files (%s); fix them or apply with --force", profile, strings.Join(names, ", "))
+}
+
+func cmdValidate(c *cli.Context) error {
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	profiles := c.Args().Slice()
+	if len(profiles) == 0 {
+		names, err := profileNames()
+		if err != nil {
+			return err
+		}
+		profiles = names
+	}
+	if len(profiles) == 0 {
+		boxInfo("No Profiles Found", "Create your first profile with 'devswitch create <name>'")
+		return nil
+	}
+
+	invalid := 0
+	for _, profile := range profiles {
+		if err := profileExists(profile); err != nil {
+			return err
+		}
+		var b strings.Builder
+		var checks []fileCheck
+		dir, err := profileSourceDir(profile)
+		if err == nil {
+			checks, err = validateDir(profile, dir, nil)
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "✗ cannot resolve the profile: %v", err)
+			invalid++
+			boxInfo("Validate "+profile, b.String())
+			continue
+		}
+		failed := len(failedChecks(checks))
+		for _, fc := range checks {
+			switch {
+			case fc.Err != nil:
+				fmt.Fprintf(&b, "✗ %-24s %s\n    %s\n", fc.Name, fc.Kind, strings.ReplaceAll(fc.Err.Error(), "\n", "\n    "))
+			case fc.Warning != "":
+				fmt.Fprintf(&b, "! %-24s %s\n    %s\n", fc.Name, fc.Kind, fc.Warning)
+			case fc.Skipped != "":
+				fmt.Fprintf(&b, "· %-24s skipped, %s\n", fc.Name, fc.Skipped)
+			case fc.Kind == "":
+				fmt.Fprintf(&b, "· %-24s not checked\n", fc.Name)
+			default:
+				fmt.Fprintf(&b, "✓ %-24s %s\n", fc.Name, fc.Kind)
+			}
+		}
+		if len(checks) == 0 {
+			b.WriteString("No files to check\n")
+		}
+		if failed > 0 {
+			invalid++
+			fmt.Fprintf(&b, "\n%d invalid file(s)", failed)
+		} else {
+			b.WriteString("\nAll files are valid")
+		}
+		boxInfo("Validate "+profile, b.String())
+	}
+	if invalid > 0 {
+		return cli.Exit(fmt.Sprintf("%d profile(s) have invalid files", invalid), 1)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckINI(t *testing.T) {
+	tests := []struct {
+		name, in, wantErr string
+	}{
+		{"sections and keys", "[default]\nregion = eu-west-1\n\n[profile ops]\noutput=json\n", ""},
+		{"value containing =", "[default]\nquery = a=b&c=d\n", ""},
+		{"comments", "# hash\n; semicolon\n[default]\n  # indented\nkey = v\n", ""},
+		{"key without spaces around =", "registry=https://registry.example/\n", ""},
+		{"empty value", "[default]\nkey =\n", ""},
+		{"malformed section header", "[default\nkey = v\n", "line 1: malformed section header"},
+		{"bare line", "[default]\nregion\n", "line 2: expected key = value"},
+		{"line starting with =", "= v\n", "line 1: expected key = value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkINI([]byte(tt.in))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckDotenv(t *testing.T) {
+	tests := []struct {
+		name, in, wantErr string
+	}{
+		{"plain", "A=1\nB_2=two\n", ""},
+		{"value containing =", "URL=http://x?a=b&c=d\n", ""},
+		{"export prefix", "export A=1\nexport  B = 2\n", ""},
+		{"double quoted", `A="with spaces"` + "\n", ""},
+		{"single quoted", "A='with spaces'\n", ""},
+		{"quoted with a trailing comment", `A="x" # note` + "\n", ""},
+		{"quote inside the value", `A=it's` + "\n", ""},
+		{"empty value", "A=\n", ""},
+		{"comments and blank lines", "# c\n\n  # indented\nA=1\n", ""},
+		{"unterminated double quote", "A=1\nB=\"open\n", `line 2: unterminated " quote`},
+		{"unterminated single quote", "A='open\n", "line 1: unterminated ' quote"},
+		{"lone quote", `A="` + "\n", `line 1: unterminated " quote`},
+		{"key starting with a digit", "1A=x\n", "line 1: expected KEY=value"},
+		{"missing =", "A\n", "line 1: expected KEY=value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDotenv([]byte(tt.in))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckProfileFileKeyValue(t *testing.T) {
+	tests := []struct {
+		name, file, in string
+		kind           string
+		wantErr        bool
+		wantWarning    bool
+	}{
+		{"valid npmrc", ".npmrc", "registry=https://registry.example/\n//registry.example/:_authToken=x\n", "npmrc", false, false},
+		{"npmrc bare key only warns", ".npmrc", "registry=https://registry.example/\nstrict-ssl\n", "npmrc", false, true},
+		{"aws config bare line fails", "aws_config", "[default]\nregion\n", "ini", true, false},
+		{"aws credentials", "aws_credentials", "[default]\naws_secret_access_key = a/b=c\n", "ini", false, false},
+		{"env file", ".env", "export A=1\nB=\"x=y\"\n", "dotenv", false, false},
+		{"env variant with a bad line", ".env.local", "A=1\nnot a pair\n", "dotenv", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := checkProfileFile(tt.file, []byte(tt.in))
+			if fc.Kind != tt.kind {
+				t.Errorf("kind %q, want %q", fc.Kind, tt.kind)
+			}
+			if (fc.Err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error: %v", fc.Err, tt.wantErr)
+			}
+			if (fc.Warning != "") != tt.wantWarning {
+				t.Errorf("warning %q, want warning: %v", fc.Warning, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// What watch does when a managed file drifts from the last apply.
+const (
+	watchRecord  = "record"  // log the change
+	watchSave    = "save"    // copy the edit into the active profile
+	watchRestore = "restore" // put the profile's version back
+	watchIgnore  = "ignore"  // do nothing
+)
+
+const (
+	// watchSettle lets editors finish writing before a change is acted on.
+	watchSettle = 500 * time.Millisecond
+	// watchRescan is how often files are checked when notifications are
+	// available, for targets whose directory could not be watched.
+	watchRescan = time.Minute
+)
+
+// watchStatus is kept in the state directory while watch runs, for other
+// commands and scripts to read.
+type watchStatus struct {
+	PID     int           `json:"pid"`
+	Mode    string        `json:"mode"` // inotify or poll
+	Started time.Time     `json:"started"`
+	Updated time.Time     `json:"updated"`
+	Stopped *time.Time    `json:"stopped,omitempty"`
+	Profile string        `json:"profile,omitempty"`
+	Files   []watchedFile `json:"files"`
+	Events  int           `json:"events"`
+}
+
+type watchedFile struct {
+	Name      string     `json:"name"`
+	Path      string     `json:"path"`
+	Policy    string     `json:"policy"`
+	State     string     `json:"state"`
+	LastEvent *time.Time `json:"last_event,omitempty"`
+}
+
+// driftEvent is one line of the drift log.
+type driftEvent struct {
+	Time    time.Time `json:"time"`
+	Profile string    `json:"profile"`
+	File    string    `json:"file"`
+	Path    string    `json:"path"`
+	State   string    `json:"state"`
+	Hash    string    `json:"hash,omitempty"` // sha256 of the changed file
+	Policy  string    `json:"policy"`
+	Action  string    `json:"action"` // recorded, saved, restored, skipped or failed
+	Error   string    `json:"error,omitempty"`
+}
+
+func watchDir() string {
+	return filepath.Join(devDir(), "watch")
+}
+
+func watchStatusPath() string {
+	return filepath.Join(watchDir(), "status.json")
+}
+
+func driftLogPath() string {
+	return filepath.Join(logsDir(), "drift.jsonl")
+}
+
+func validWatchPolicy(p string) bool {
+	switch p {
+	case watchRecord, watchSave, watchRestore, watchIgnore:
+		return true
+	}
+	return false
+}
+
+func loadWatchStatus() (*watchStatus, error) {
+	data, err := os.ReadFile(watchStatusPath())
+	if err != nil {
+		return nil, err
+	}
+	ws := &watchStatus{}
+	if err := json.Unmarshal(data, ws); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", watchStatusPath(), err)
+	}
+	return ws, nil
+}
+
+func (ws *watchStatus) save() error {
+	ws.Updated = time.Now()
+	data, err := json.MarshalIndent(ws, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(watchStatusPath(), data, 0o644)
+}
+
+// running reports whether the watcher that wrote the status is alive.
+func (ws *watchStatus) running() bool {
+	return ws.Stopped == nil && processRunning(ws.PID)
+}
+
+func appendDriftLog(e *driftEvent) error {
+	if err := os.MkdirAll(logsDir(), 0o700); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(driftLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// watcher follows the targets of the last apply.
+type watcher struct {
+	policy string // for files the manifest has no policy for
+	status *watchStatus
+	st     *appliedState
+	seen   map[string]string // file -> hash at the last scan, "" if missing
+}
+
+// reload picks up the state of the latest apply. It reports whether the set
+// of watched paths changed.
+func (w *watcher) reload() (bool, error) {
+	profile, _ := readCurrentProfile()
+	st, err := loadAppliedState()
+	if err != nil {
+		return false, err
+	}
+	if st != nil && st.Profile != profile {
+		st = nil
+	}
+	if w.st != nil && st != nil && st.Profile == w.st.Profile && st.Time.Equal(w.st.Time) {
+		w.st = st
+		return false, nil
+	}
+
+	w.st = st
+	w.status.Profile = profile
+	w.status.Files = nil
+	w.seen = map[string]string{}
+	if st == nil {
+		if profile != "" {
+			color.Yellow("⏳ No record of applying %s; re-apply it to start watching", profile)
+		} else {
+			color.Yellow("⏳ No active profile; waiting for an apply")
+		}
+		return true, w.status.save()
+	}
+
+	manifest, err := loadResolvedManifest(st.Profile)
+	if err != nil {
+		color.Yellow("⚠️  %v; using the default policy", err)
+		manifest = &profileManifest{}
+	}
+	def := w.policy
+	if p, ok := manifest.Watch["*"]; ok {
+		def = p
+	}
+	var names []string
+	for name := range st.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rec := st.Files[name]
+		policy := def
+		if p, ok := manifest.Watch[name]; ok {
+			policy = p
+		} else if p, ok := manifest.Watch[strings.TrimPrefix(name, ".")]; ok {
+			policy = p
+		}
+		if !validWatchPolicy(policy) {
+			color.Yellow("⚠️  Unknown watch policy %q for %s; recording changes", policy, name)
+			policy = watchRecord
+		}
+		// drift that happened before the watch started is reported once
+		w.seen[name] = rec.Hash
+		w.status.Files = append(w.status.Files, watchedFile{Name: name, Path: rec.Path, Policy: policy, State: fileInSync})
+	}
+	color.Blue("👀 Watching %d files of %s", len(names), st.Profile)
+	return true, w.status.save()
+}
+
+// paths are the files to be notified about, including the apply record so
+// that a new apply is picked up.
+func (w *watcher) paths() []string {
+	paths := []string{appliedStatePath(), filepath.Join(devDir(), "current_profile.txt")}
+	for _, f := range w.status.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+// scan compares every watched file with its last scan and acts on drift.
+func (w *watcher) scan() {
+	if w.st == nil {
+		return
+	}
+	changed := false
+	for i := range w.status.Files {
+		wf := &w.status.Files[i]
+		rec, ok := w.st.Files[wf.Name]
+		if !ok {
+			continue
+		}
+		current, err := contentHash(rec.Path)
+		if err != nil {
+			current = ""
+		}
+		state := fileInSync
+		switch {
+		case current == "":
+			state = fileDeleted
+		case current != rec.Hash:
+			state = fileModified
+		}
+		if state != wf.State {
+			wf.State = state
+			changed = true
+		}
+		if current == w.seen[wf.Name] {
+			continue
+		}
+		w.seen[wf.Name] = current
+		changed = true
+		if state == fileInSync || wf.Policy == watchIgnore {
+			continue
+		}
+
+		e := &driftEvent{
+			Time:    time.Now(),
+			Profile: w.st.Profile,
+			File:    wf.Name,
+			Path:    rec.Path,
+			State:   wf.State,
+			Hash:    current,
+			Policy:  wf.Policy,
+			Action:  "recorded",
+		}
+		var actErr error
+		switch {
+		case wf.Policy == watchSave && wf.State == fileModified:
+			actErr = w.save(wf.Name)
+			e.Action = "saved"
+		case wf.Policy == watchRestore:
+			actErr = w.restore(wf.Name)
+			e.Action = "restored"
+		}
+		if errors.Is(actErr, errWatchStale) {
+			e.Action = "skipped"
+			e.Error = actErr.Error()
+		} else if actErr != nil {
+			e.Action = "failed"
+			e.Error = actErr.Error()
+		}
+		w.report(e)
+		if e.Action == "saved" || e.Action == "restored" {
+			wf.State = fileInSync
+			w.seen[wf.Name], _ = contentHash(rec.Path)
+		}
+		wf.LastEvent = &e.Time
+		w.status.Events++
+	}
+	if changed {
+		if err := w.status.save(); err != nil {
+			color.Yellow("⚠️  Could not write watch status: %v", err)
+		}
+	}
+}
+
+func (w *watcher) report(e *driftEvent) {
+	stamp := e.Time.Local().Format("15:04:05")
+	switch e.Action {
+	case "saved":
+		color.Green("%s 💾 %s %s, saved into %s", stamp, e.File, e.State, e.Profile)
+	case "restored":
+		color.Green("%s ↩️  %s %s, restored from %s", stamp, e.File, e.State, e.Profile)
+	case "skipped":
+		color.Yellow("%s ⏭️  %s %s, left alone: %s", stamp, e.File, e.State, e.Error)
+	case "failed":
+		color.Red("%s ❌ %s %s, %s failed: %s", stamp, e.File, e.State, e.Policy, e.Error)
+	default:
+		color.Yellow("%s ✎ %s %s", stamp, e.File, e.State)
+	}
+	if err := appendDriftLog(e); err != nil {
+		color.Yellow("⚠️  Could not write drift log: %v", err)
+	}
+}
+
+// logOp records an automatic save or restore in the operation log, like
+// the commands that do the same by hand.
+func (w *watcher) logOp(action func() error) error {
+	activeOp = newOpEvent("watch")
+	noteTransition(w.st.Profile, w.st.Profile)
+	err := action()
+	activeOp.Result = opOK
+	if err != nil {
+		activeOp.Result, activeOp.Error = opError, err.Error()
+	}
+	if lerr := appendOpLog(activeOp); lerr != nil {
+		color.Yellow("⚠️  Could not write operation log: %v", lerr)
+	}
+	activeOp = nil
+	return err
+}
+
+// errWatchStale stops an automatic save or restore planned against an
+// apply that has since been replaced.
+var errWatchStale = errors.New("the active profile changed since the edit was seen")
+
+// locked runs action under the state lock, so that it cannot interleave with
+// an apply, undo or rollback, and only if the apply it was planned against
+// is still the current one.
+func (w *watcher) locked(action func() error) error {
+	lock, err := acquireStateLock(stateLockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	profile, _ := readCurrentProfile()
+	st, err := loadAppliedState()
+	if err != nil {
+		return err
+	}
+	if st == nil || st.Profile != profile || st.Profile != w.st.Profile || !st.Time.Equal(w.st.Time) {
+		return errWatchStale
+	}
+	// the record may have been updated since, e.g. by devswitch save
+	w.st = st
+	return action()
+}
+
+// save copies the edited file into the profile it was applied from.
+func (w *watcher) save(name string) error {
+	return w.locked(func() error {
+		return w.logOp(func() error {
+			cfg, ok := configByName(name)
+			if !ok {
+				return fmt.Errorf("unknown config file %s", name)
+			}
+			member, err := saveTarget(w.st.Profile, "", name)
+			if err != nil {
+				return err
+			}
+			item, err := prepareSave(w.st, cfg, member, false)
+			if err != nil {
+				return err
+			}
+			if err := item.write(w.st); err != nil {
+				return err
+			}
+			return w.st.save()
+		})
+	})
+}
+
+// restore writes the profile's content as of the last apply back to the
+// target.
+func (w *watcher) restore(name string) error {
+	return w.locked(func() error {
+		return w.logOp(func() error {
+			rec := w.st.Files[name]
+			data, err := os.ReadFile(w.st.basePath(name))
+			if err != nil {
+				return fmt.Errorf("no applied copy of %s: %v", name, err)
+			}
+			perm := os.FileMode(0o644)
+			if info, err := os.Stat(rec.Path); err == nil {
+				perm = info.Mode().Perm()
+			}
+			if err := os.MkdirAll(filepath.Dir(rec.Path), 0o755); err != nil {
+				return err
+			}
+			before, _ := contentHash(rec.Path)
+			if err := os.WriteFile(rec.Path, data, perm); err != nil {
+				return err
+			}
+			noteFileChange(name, rec.Path, before)
+			if err := w.st.record(name, rec.Path); err != nil {
+				return err
+			}
+			return w.st.save()
+		})
+	})
+}
+
+func cmdWatch(c *cli.Context) error {
+	if c.Bool("status") {
+		return printWatchStatus()
+	}
+	policy := c.String("policy")
+	if !validWatchPolicy(policy) {
+		return fmt.Errorf("--policy must be record, save, restore or ignore, got %q", policy)
+	}
+	if err := os.MkdirAll(watchDir(), 0o700); err != nil {
+		return err
+	}
+	if ws, err := loadWatchStatus(); err == nil && ws.PID != os.Getpid() && ws.running() {
+		return fmt.Errorf("already watching (pid %d)", ws.PID)
+	}
+
+	interval := c.Duration("interval")
+	mode := notifyMode
+	if c.Bool("poll") {
+		mode = "poll"
+	}
+	w := &watcher{
+		policy: policy,
+		status: &watchStatus{PID: os.Getpid(), Mode: mode, Started: time.Now()},
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer func() {
+		now := time.Now()
+		w.status.Stopped = &now
+		w.status.save()
+	}()
+
+	var events <-chan struct{}
+	closeNotify := func() {}
+	defer func() { closeNotify() }()
+	for {
+		changed, err := w.reload()
+		if err != nil {
+			return err
+		}
+		if changed && w.status.Mode != "poll" {
+			closeNotify()
+			events, closeNotify, err = notifyChanges(w.paths())
+			if err != nil {
+				color.Yellow("⚠️  %v; polling every %s", err, interval)
+				w.status.Mode = "poll"
+				events, closeNotify = nil, func() {}
+			}
+		}
+		w.scan()
+
+		wait := interval
+		if w.status.Mode != "poll" {
+			wait = watchRescan
+		}
+		select {
+		case <-stop:
+			color.Blue("👋 Stopped watching")
+			return nil
+		case _, ok := <-events:
+			if !ok {
+				// the notifier failed; start a new one
+				w.st = nil
+				continue
+			}
+			time.Sleep(watchSettle)
+			select {
+			case <-events:
+			default:
+			}
+		case <-time.After(wait):
+		}
+	}
+}
+
+func printWatchStatus() error {
+	ws, err := loadWatchStatus()
+	if os.IsNotExist(err) {
+		boxInfo("Watch", "No watcher has run yet; start one with 'devswitch watch'")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if ws.running() {
+		fmt.Fprintf(&b, "Running (pid %d, %s) since %s\n", ws.PID, ws.Mode, ws.Started.Local().Format("2006-01-02 15:04:05"))
+	} else {
+		stopped := ws.Updated
+		if ws.Stopped != nil {
+			stopped = *ws.Stopped
+		}
+		fmt.Fprintf(&b, "Not running (stopped %s)\n", stopped.Local().Format("2006-01-02 15:04:05"))
+	}
+	if ws.Profile != "" {
+		fmt.Fprintf(&b, "Profile: %s\n", ws.Profile)
+	}
+	for _, f := range ws.Files {
+		fmt.Fprintf(&b, "\n  %s %-20s %-17s %s", stateSymbol(f.State), f.Name, f.State, f.Policy)
+	}
+	fmt.Fprintf(&b, "\n\n%d event(s), see %s", ws.Events, driftLogPath())
+	boxInfo("Watch", b.String())
+	return nil
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const notifyMode = "inotify"
+
+// notifyChanges signals on the returned channel when one of paths may have
+// changed. Parent directories are watched rather than the files, so editors
+// that replace a file by renaming are noticed too. Paths whose directory
+// does not exist are not watched.
+func notifyChanges(paths []string) (<-chan struct{}, func(), error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+	// non-blocking, so that Close interrupts a pending Read
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	names := map[int]map[string]bool{} // watch descriptor -> file names
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+		unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ATTRIB
+	for _, p := range paths {
+		wd, err := unix.InotifyAddWatch(fd, filepath.Dir(p), mask)
+		if err != nil {
+			continue
+		}
+		if names[wd] == nil {
+			names[wd] = map[string]bool{}
+		}
+		names[wd][filepath.Base(p)] = true
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				close(ch)
+				return
+			}
+			hit := false
+			for off := 0; off+unix.SizeofInotifyEvent <= n; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				start := off + unix.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[start:start+int(ev.Len)]), "\x00")
+				off = start + int(ev.Len)
+				if ev.Mask&unix.IN_Q_OVERFLOW != 0 || names[int(ev.Wd)][name] {
+					hit = true
+				}
+			}
+			if hit {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ch, func() { f.Close() }, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const notifyMode = "poll"
+
+// notifyChanges is only implemented with inotify; elsewhere watch polls.
+func notifyChanges(paths []string) (<-chan struct{}, func(), error) {
+	return nil, nil, errors.New("file notifications are not supported on this platform")
+}
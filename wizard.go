@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+// createPlan describes a profile before it is written. The wizard and the
+// scripting flags both fill one in, so they produce identical profiles.
+type createPlan struct {
+	Profile  string
+	GitName  string
+	Email    string
+	Editor   string
+	Template string
+	Capture  []string // names of detected config files to copy
+	SSHKey   bool
+}
+
+// plannedFile is one file of the new profile and where its content came from.
+type plannedFile struct {
+	Name    string
+	Source  string
+	Content []byte
+	Perm    os.FileMode
+}
+
+// createWizardFlags are the create flags that go through a createPlan.
+var createWizardFlags = []string{"interactive", "git-name", "email", "editor", "capture", "ssh-key", "dry-run"}
+
+func wizardRequested(c *cli.Context) bool {
+	for _, f := range createWizardFlags {
+		if c.IsSet(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// newProfilePath validates a name for a profile that does not exist yet.
+func newProfilePath(profile string) (string, error) {
+	if strings.Contains(profile, profileSeparator) {
+		return "", fmt.Errorf("profile names cannot contain %q, it joins composed profiles", profileSeparator)
+	}
+	if err := checkName("profile", profile); err != nil {
+		return "", err
+	}
+	profPath := filepath.Join(profilesDir(), profile)
+	if _, err := os.Stat(profPath); err == nil {
+		return "", fmt.Errorf("profile %s already exists", profile)
+	}
+	return profPath, nil
+}
+
+// checkName rejects profile and template names that are not a single plain
+// directory entry, so a name can never reach outside its directory.
+func checkName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s name required", kind)
+	}
+	if !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	return nil
+}
+
+// presentConfigs returns the detected config files that exist on this machine.
+func presentConfigs() []configFile {
+	var present []configFile
+	for _, cfg := range detectConfigFiles() {
+		if _, err := os.Stat(cfg.Src()); err == nil {
+			present = append(present, cfg)
+		}
+	}
+	return present
+}
+
+// parseCapture turns "all", "none" or a comma separated list of config names
+// (with or without the leading dot) into names from present.
+func parseCapture(list string, present []configFile) ([]string, error) {
+	list = strings.TrimSpace(list)
+	switch list {
+	case "", "none":
+		return nil, nil
+	case "all":
+		var names []string
+		for _, cfg := range present {
+			names = append(names, cfg.Name)
+		}
+		return names, nil
+	}
+
+	var names []string
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		found := ""
+		for i, cfg := range present {
+			if part == cfg.Name || "."+part == cfg.Name || part == strconv.Itoa(i+1) {
+				found = cfg.Name
+				break
+			}
+		}
+		if found == "" {
+			return nil, fmt.Errorf("%s is not a config file found on this machine", part)
+		}
+		names = append(names, found)
+	}
+	return names, nil
+}
+
+// gitGlobal reads a value from the global git config, or "" if unset.
+func gitGlobal(key string) string {
+	out, err := exec.Command("git", "config", "--global", "--get", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// planFromFlags builds a plan from the scripting flags. Without --template
+// all present configs are captured, as plain create does; with one nothing
+// is unless --capture says so.
+func planFromFlags(c *cli.Context) (*createPlan, error) {
+	p := &createPlan{
+		Profile:  c.Args().First(),
+		GitName:  c.String("git-name"),
+		Email:    c.String("email"),
+		Editor:   c.String("editor"),
+		Template: c.String("template"),
+		SSHKey:   c.Bool("ssh-key"),
+	}
+	if p.Template != "" {
+		if _, err := findTemplate(p.Template); err != nil {
+			return nil, err
+		}
+	}
+	capture := c.String("capture")
+	if !c.IsSet("capture") && p.Template == "" {
+		capture = "all"
+	}
+	names, err := parseCapture(capture, presentConfigs())
+	if err != nil {
+		return nil, err
+	}
+	p.Capture = names
+	return p, nil
+}
+
+// confirm asks a yes/no question.
+func confirm(r *bufio.Reader, question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := ask(r, fmt.Sprintf("%s (%s)", question, hint), "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		color.Yellow("Please answer y or n")
+	}
+}
+
+// runWizard asks for everything the flags did not already answer. Flag
+// values and the current git identity are offered as defaults.
+func runWizard(r *bufio.Reader, p *createPlan, c *cli.Context) error {
+	var err error
+	for {
+		if p.Profile, err = ask(r, "Profile name", p.Profile); err != nil {
+			return err
+		}
+		if _, perr := newProfilePath(p.Profile); perr != nil {
+			color.Yellow("%v", perr)
+			p.Profile = ""
+			continue
+		}
+		break
+	}
+
+	if p.GitName, err = ask(r, "Your name (git user.name)", firstNonEmpty(p.GitName, gitGlobal("user.name"))); err != nil {
+		return err
+	}
+	if p.Email, err = ask(r, "Email (git user.email)", firstNonEmpty(p.Email, gitGlobal("user.email"))); err != nil {
+		return err
+	}
+	if p.Editor, err = ask(r, "Editor (git core.editor)", firstNonEmpty(p.Editor, gitGlobal("core.editor"), os.Getenv("EDITOR"))); err != nil {
+		return err
+	}
+
+	names := templateNames()
+	for {
+		answer, err := ask(r, fmt.Sprintf("Start from template (%s, none)", strings.Join(names, ", ")), firstNonEmpty(p.Template, "none"))
+		if err != nil {
+			return err
+		}
+		if answer == "none" {
+			p.Template = ""
+			break
+		}
+		if _, err := findTemplate(answer); err == nil {
+			p.Template = answer
+			break
+		}
+		color.Yellow("Unknown template %s", answer)
+	}
+
+	present := presentConfigs()
+	if len(present) > 0 {
+		fmt.Println("Config files found on this machine:")
+		for i, cfg := range present {
+			fmt.Printf("  %2d) %-20s %s\n", i+1, cfg.Name, cfg.Src())
+		}
+		def := "all"
+		if c.IsSet("capture") {
+			def = strings.Join(p.Capture, ",")
+		} else if p.Template != "" {
+			def = "none"
+		}
+		if def == "" {
+			def = "none"
+		}
+		for {
+			answer, err := ask(r, "Capture which files (numbers or names, all, none)", def)
+			if err != nil {
+				return err
+			}
+			if p.Capture, err = parseCapture(answer, present); err != nil {
+				color.Yellow("%v", err)
+				continue
+			}
+			break
+		}
+	}
+
+	p.SSHKey, err = confirm(r, "Generate a new ed25519 SSH key for this profile?", p.SSHKey)
+	return err
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// identityOverlay returns the gitconfig lines for the plan's identity, or
+// nil when none was given.
+func (p *createPlan) identityOverlay() []byte {
+	var b strings.Builder
+	if p.GitName != "" || p.Email != "" {
+		b.WriteString("[user]\n")
+		if p.GitName != "" {
+			fmt.Fprintf(&b, "\tname = %s\n", p.GitName)
+		}
+		if p.Email != "" {
+			fmt.Fprintf(&b, "\temail = %s\n", p.Email)
+		}
+	}
+	if p.Editor != "" {
+		fmt.Fprintf(&b, "[core]\n\teditor = %s\n", p.Editor)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return []byte(b.String())
+}
+
+// files assembles the content of the new profile: template files first,
+// then captured files replacing them, then the identity merged into
+// .gitconfig and finally the generated key.
+func (p *createPlan) files() ([]plannedFile, error) {
+	files := map[string]*plannedFile{}
+	if p.Template != "" {
+		t, err := findTemplate(p.Template)
+		if err != nil {
+			return nil, err
+		}
+		tfiles, err := t.files()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range tfiles {
+			files[f.Path] = &plannedFile{Name: f.Path, Source: p.Template + " template", Content: f.Content, Perm: f.Perm}
+		}
+	}
+
+	capture := map[string]bool{}
+	for _, name := range p.Capture {
+		capture[name] = true
+	}
+	for _, cfg := range detectConfigFiles() {
+		if !capture[cfg.Name] {
+			continue
+		}
+		info, err := os.Stat(cfg.Src())
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(cfg.Src())
+		if err != nil {
+			return nil, err
+		}
+		files[cfg.Name] = &plannedFile{Name: cfg.Name, Source: cfg.Src(), Content: data, Perm: info.Mode().Perm()}
+	}
+
+	if overlay := p.identityOverlay(); overlay != nil {
+		if f, ok := files[".gitconfig"]; ok {
+			f.Content = mergeINI(f.Content, overlay)
+			f.Source += " + identity"
+		} else {
+			files[".gitconfig"] = &plannedFile{Name: ".gitconfig", Source: "identity", Content: overlay, Perm: 0o644}
+		}
+	}
+
+	if p.SSHKey {
+		priv, pub, err := generateSSHKey(firstNonEmpty(p.Email, p.Profile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ssh key: %v", err)
+		}
+		files["ssh_id_ed25519"] = &plannedFile{Name: "ssh_id_ed25519", Source: "new ed25519 key", Content: priv, Perm: 0o600}
+		files["ssh_id_ed25519.pub"] = &plannedFile{Name: "ssh_id_ed25519.pub", Source: "new ed25519 key", Content: pub, Perm: 0o644}
+	}
+
+	var out []plannedFile
+	for _, name := range sortedKeys(files) {
+		out = append(out, *files[name])
+	}
+	return out, nil
+}
+
+// generateSSHKey returns an unencrypted OpenSSH private key and its
+// authorized_keys line.
+func generateSSHKey(comment string) (priv, pub []byte, err error) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(privKey, comment)
+	if err != nil {
+		return nil, nil, err
+	}
+	sshPub, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment + "\n"
+	return pem.EncodeToMemory(block), []byte(line), nil
+}
+
+func (p *createPlan) preview(files []plannedFile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Profile:  %s\n", p.Profile)
+	if p.GitName != "" || p.Email != "" {
+		fmt.Fprintf(&b, "Identity: %s\n", strings.TrimSpace(p.GitName+" <"+p.Email+">"))
+	}
+	if p.Editor != "" {
+		fmt.Fprintf(&b, "Editor:   %s\n", p.Editor)
+	}
+	if p.Template != "" {
+		fmt.Fprintf(&b, "Template: %s\n", p.Template)
+	}
+	b.WriteString("\nFiles:\n")
+	if len(files) == 0 {
+		b.WriteString("  (none)\n")
+	}
+	for _, f := range files {
+		fmt.Fprintf(&b, "  %-22s %s\n", f.Name, f.Source)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// cmdCreateWizard handles create when --interactive or any of the
+// scripting flags is given.
+func cmdCreateWizard(c *cli.Context) error {
+	p, err := planFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	var reader *bufio.Reader
+	if c.Bool("interactive") {
+		if !isatty.IsTerminal(os.Stdin.Fd()) {
+			return fmt.Errorf("--interactive needs a terminal; pass --git-name, --email, --editor, --template, --capture and --ssh-key instead")
+		}
+		reader = bufio.NewReader(os.Stdin)
+		if err := runWizard(reader, p, c); err != nil {
+			return err
+		}
+	}
+
+	profPath, err := newProfilePath(p.Profile)
+	if err != nil {
+		return err
+	}
+	files, err := p.files()
+	if err != nil {
+		return err
+	}
+
+	boxInfo("New Profile", p.preview(files))
+	if c.Bool("dry-run") {
+		color.Yellow("Dry run, nothing written")
+		return nil
+	}
+	if reader != nil {
+		ok, err := confirm(reader, fmt.Sprintf("Create profile %s?", p.Profile), true)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			color.Yellow("Cancelled")
+			return nil
+		}
+	}
+
+	if err := ensureDirs(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(profPath, 0o755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		dst := filepath.Join(profPath, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			os.RemoveAll(profPath)
+			return err
+		}
+		if err := os.WriteFile(dst, f.Content, f.Perm); err != nil {
+			os.RemoveAll(profPath)
+			return fmt.Errorf("failed to write %s: %v", f.Name, err)
+		}
+	}
+
+	if err := initProfileMeta(c, profPath); err != nil {
+		return err
+	}
+	noteProfileFiles(p.Profile)
+
+	msg := p.Profile
+	if p.SSHKey {
+		msg += fmt.Sprintf("\n\nPublic key: %s\nAdd it to your Git host before applying.", filepath.Join(profPath, "ssh_id_ed25519.pub"))
+	}
+	boxInfo("Profile Created", msg)
+	return nil
+}